@@ -0,0 +1,47 @@
+// Package bench compares codecs in a module of its own so that their dependencies stay out of gql-ws
+package bench_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	jsoniter "github.com/json-iterator/go"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+func BenchmarkCodec(b *testing.B) {
+	items := make([]interface{}, 100)
+	for i := range items {
+		items[i] = map[string]interface{}{"id": fmt.Sprint(i), "name": `item`, "price": float64(i) * 1.5, "tags": []interface{}{`a`, `b`}}
+	}
+	id := `id`
+	msg := &gqlwsmessage.Message{Type: gqlwsmessage.Next, ID: &id, Payload: &graphql.Result{Data: map[string]interface{}{"items": items}}}
+	codecs := []struct {
+		name  string
+		codec gqlwsmessage.Codec
+	}{
+		{"encoding/json", gqlwsmessage.StdCodec{}},
+		{"jsoniter", jsoniter.ConfigCompatibleWithStandardLibrary},
+		{"jsoniter fastest", jsoniter.ConfigFastest},
+	}
+	for _, c := range codecs {
+		enc := gqlwsmessage.NewJSONEncoding(c.codec)
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				data, err := enc.Marshal(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var decoded gqlwsmessage.Message
+				if err := enc.Unmarshal(data, &decoded); err != nil {
+					b.Fatal(err)
+				}
+				var res graphql.Result
+				if err := enc.DecodePayload(decoded.Payload, &res); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
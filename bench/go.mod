@@ -0,0 +1,17 @@
+module github.com/onichandame/gql-ws/bench
+
+go 1.18
+
+require (
+	github.com/graphql-go/graphql v0.8.0
+	github.com/json-iterator/go v1.1.12
+	github.com/onichandame/gql-ws v0.0.0
+)
+
+require (
+	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/ugorji/go/codec v1.2.14 // indirect
+)
+
+replace github.com/onichandame/gql-ws => ../
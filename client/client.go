@@ -2,7 +2,8 @@ package gqlwsclient
 
 import (
 	"errors"
-	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,124 +13,290 @@ import (
 	goutils "github.com/onichandame/go-utils"
 	gqlwserror "github.com/onichandame/gql-ws/error"
 	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
 )
 
 type Client struct {
 	*Config
 
-	reader, writer chan *gqlwsmessage.Message
-	breaker        chan error
-	done           chan interface{}
-	init           chan *gqlwsmessage.Message
-	sm             *subMan
-	inited         bool
-	err            error
+	writer chan *gqlwsmessage.Message
+	// closed by Close
+	closing   chan interface{}
+	closeOnce sync.Once
+	done      chan interface{}
+	init      chan *gqlwsmessage.Message
+	transport gqlwstransport.Transport
+	sm        *subMan
+	inited    int32
+	err       error
+	// counts the connections, telling apart those a subscribe message was written to
+	conns uint64
+	// guards transport, sessionToken and patches, which are replaced on reconnect
+	lock sync.RWMutex
+	// the session resumed on reconnect, and the sequence of the last message received in it
+	sessionToken string
+	lastSeq      uint64
+	// whether the server sends results as JSON patches
+	patches bool
 }
 
 func NewClient(cfg *Config) *Client {
 	var c Client
 	cfg.init()
 	c.Config = cfg
-	c.reader = make(chan *gqlwsmessage.Message)
 	c.writer = make(chan *gqlwsmessage.Message)
-	c.breaker = make(chan error)
+	c.closing = make(chan interface{})
 	c.done = make(chan interface{})
+	c.init = make(chan *gqlwsmessage.Message, 1)
 	c.sm = newSubMan()
-	goutils.Retry(func() { c.dial() }, &goutils.RetryConfig{Attempts: uint(cfg.ReconnectAttempts) + 1})
+	c.connect()
 	return &c
 }
+
+var errClosed = errors.New(`terminated by user`)
+
+var errLost = errors.New(`connection lost before the operation completed`)
+
 func (c *Client) Close() {
-	defer goutils.RecoverToErr(new(error))
-	c.breaker <- errors.New(`terminated by user`)
+	c.closeOnce.Do(func() { close(c.closing) })
 }
 func (c *Client) Wait() {
 	<-c.done
 }
 func (c *Client) Error() error { return c.err }
 
+// dial establishes a connection. once it drops, the client reconnects if the server issued a session to resume
 func (c *Client) dial() {
-	conn, _, err := websocket.DefaultDialer.Dial(c.URL, http.Header{"Sec-WebSocket-Protocol": []string{`graphql-transport-ws`}})
+	transport, err := c.getTransport()
 	goutils.Assert(err)
-	// cleanup
-	go func() {
-		defer close(c.done)
-		defer conn.Close()
-		err := <-c.breaker
-		c.err = err
-		if err != nil {
-			if conn.WriteControl(websocket.CloseMessage, []byte(err.Error()), time.Now().Add(c.GraceClosePeriod)) == nil {
-				time.Sleep(c.GraceClosePeriod)
-			}
+	c.lock.Lock()
+	c.transport = transport
+	c.lock.Unlock()
+	reader := make(chan *gqlwsmessage.Message)
+	breaker := make(chan error)
+	closed := make(chan interface{})
+	// ends the connection with err unless it has already ended
+	fail := func(err error) {
+		select {
+		case breaker <- err:
+		case <-closed:
 		}
-	}()
-	// reader
+	}
+	// cleanup
 	go func() {
 		var err error
-		defer func() {
-			goutils.RecoverToErr(new(error))
-			c.breaker <- err
-		}()
-		defer goutils.RecoverToErr(&err)
-		for {
-			var msg gqlwsmessage.Message
-			goutils.Assert(conn.ReadJSON(&msg))
-			c.reader <- &msg
+		select {
+		case err = <-breaker:
+		case <-c.closing:
+			err = errClosed
+		}
+		close(closed)
+		transport.Close(closeCode(err))
+		if c.resumable(err) && goutils.Try(c.connect) == nil {
+			return
 		}
+		c.err = err
+		close(c.done)
 	}()
-	// writer
+	// reader
 	go func() {
 		var err error
-		defer func() {
-			goutils.RecoverToErr(new(error))
-			c.breaker <- err
-		}()
+		defer func() { fail(err) }()
 		defer goutils.RecoverToErr(&err)
 		for {
-			msg, ok := <-c.writer
-			if !ok {
+			msg, err := transport.ReadMessage()
+			goutils.Assert(err)
+			select {
+			case reader <- msg:
+			case <-closed:
 				return
 			}
-			goutils.Assert(conn.WriteJSON(msg))
 		}
 	}()
 	// listener
 	go func() {
-		var err error
-		defer func() {
-			c.breaker <- err
-		}()
-		defer goutils.RecoverToErr(&err)
-		for res := range c.reader {
-			go c.handleResponse(res)
+		for {
+			select {
+			case res := <-reader:
+				if err := c.handleResponse(res, fail); err != nil {
+					fail(err)
+					return
+				}
+			case <-closed:
+				return
+			}
 		}
 	}()
 	// init
-	c.init = make(chan *gqlwsmessage.Message)
-	func() {
-		var err error
-		defer func() {
-			if err != nil {
-				defer goutils.RecoverToErr(new(error))
-				c.breaker <- err
-			}
-		}()
-		defer goutils.RecoverToErr(&err)
-		c.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit, Payload: c.OnConnecting()}
-		timeout := make(chan interface{})
-		go func() {
-			time.Sleep(c.ConnectionAckTimeout)
-			if !c.inited {
-				timeout <- nil
-			}
-		}()
+	// discard an ack the previous connection dropped before consuming
+	select {
+	case <-c.init:
+	default:
+	}
+	atomic.StoreInt32(&c.inited, 0)
+	resumed := false
+	err = goutils.Try(func() {
+		// written ahead of the writer so that no queued operation precedes it
+		goutils.Assert(transport.WriteMessage(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit, Payload: c.connectionParams()}))
+		timeout := time.NewTimer(c.ConnectionAckTimeout)
+		defer timeout.Stop()
 		select {
-		case <-timeout:
-			panic(errors.New(`connection ack timeout`))
+		case <-timeout.C:
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseAcknowledgementTimeout, `Connection acknowledgement timeout`))
+		case <-closed:
 		case ack := <-c.init:
+			c.decodePayload(ack)
+			resumed = c.joinSession(ack.Payload)
+			c.acceptPatches(ack.Payload)
 			c.OnConnected(ack)
-			c.inited = true
+			atomic.StoreInt32(&c.inited, 1)
+		}
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	// writer
+	conn := atomic.AddUint64(&c.conns, 1)
+	go func() {
+		var err error
+		defer func() { fail(err) }()
+		defer goutils.RecoverToErr(&err)
+		for {
+			select {
+			case msg := <-c.writer:
+				// a subscribe message queued by Subscribe while reconnecting is also queued below. whichever comes
+				// second is dropped, as is one of an operation that has ended meanwhile
+				if msg.Type == gqlwsmessage.Subscribe && !c.sm.claim(*msg.ID, conn) {
+					continue
+				}
+				goutils.Assert(transport.WriteMessage(msg))
+			case <-closed:
+				return
+			}
 		}
 	}()
+	if !resumed {
+		// the server has forgotten the operations. a query or mutation may have taken effect, so only the
+		// subscriptions are started over
+		for id, hdl := range c.sm.transient() {
+			hdl := hdl
+			c.dispatch(id, fail, func() { hdl.OnError(gqlerrors.FormatErrors(errLost)) })
+			c.sm.del(id)
+		}
+		for id, payload := range c.sm.payloads() {
+			id := id
+			c.send(&gqlwsmessage.Message{Type: gqlwsmessage.Subscribe, ID: &id, Payload: payload})
+		}
+	}
+}
+
+// connect dials until a connection is established or the attempts run out
+func (c *Client) connect() {
+	goutils.Retry(func() { c.dial() }, &goutils.RetryConfig{Attempts: uint(c.ReconnectAttempts) + 1, Interval: c.ReconnectInterval})
+}
+
+// resumable reports whether the connection dropped rather than being closed on purpose
+func (c *Client) resumable(err error) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.sessionToken == `` || err == errClosed {
+		return false
+	}
+	var ce *gqlwserror.CloseError
+	if errors.As(err, &ce) {
+		return ce.Code() != gqlwserror.CloseNormal && ce.Code() < 4000
+	}
+	return true
+}
+
+// connectionParams returns the init payload, presenting the session to resume if there is one and asking for JSON patches
+func (c *Client) connectionParams() interface{} {
+	payload := c.OnConnecting()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.sessionToken == `` && !c.EnableJSONPatch {
+		return payload
+	}
+	params, ok := payload.(map[string]interface{})
+	if payload != nil && !ok {
+		return payload
+	}
+	extended := map[string]interface{}{}
+	if c.sessionToken != `` {
+		extended[gqlwsmessage.SessionTokenKey] = c.sessionToken
+		extended[gqlwsmessage.LastSeqKey] = atomic.LoadUint64(&c.lastSeq)
+	}
+	if c.EnableJSONPatch {
+		extended[gqlwsmessage.JSONPatchKey] = true
+	}
+	for k, v := range params {
+		extended[k] = v
+	}
+	return extended
+}
+
+// joinSession keeps the session announced in the ack payload. returns whether the previous one was resumed
+func (c *Client) joinSession(payload gqlwsmessage.Payload) bool {
+	p, _ := payload.(map[string]interface{})
+	token, _ := p[gqlwsmessage.SessionTokenKey].(string)
+	resumed, _ := p[gqlwsmessage.ResumedKey].(bool)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	resumed = resumed && token == c.sessionToken
+	if !resumed {
+		atomic.StoreUint64(&c.lastSeq, 0)
+	}
+	c.sessionToken = token
+	return resumed
+}
+
+// getTransport dials the websocket at URL unless Dial is configured
+func (c *Client) getTransport() (gqlwstransport.Transport, error) {
+	if c.Dial != nil {
+		return c.Dial()
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = c.EnableCompression
+	dialer.Subprotocols = []string{c.Encoding.Subprotocol()}
+	if c.Encoding.Subprotocol() != gqlwsmessage.Subprotocol {
+		dialer.Subprotocols = append(dialer.Subprotocols, gqlwsmessage.Subprotocol)
+	}
+	conn, _, err := dialer.Dial(c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var encoding gqlwsmessage.Encoding
+	switch conn.Subprotocol() {
+	case c.Encoding.Subprotocol():
+		encoding = c.Encoding
+	case gqlwsmessage.Subprotocol:
+		encoding = gqlwsmessage.NewJSONEncoding(c.Codec)
+	default:
+		conn.Close()
+		return nil, errors.New(`server does not support graphql-transport-ws`)
+	}
+	if c.EnableCompression && c.CompressionLevel != 0 {
+		if err := conn.SetCompressionLevel(c.CompressionLevel); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return gqlwstransport.NewWebSocket(conn, &gqlwstransport.WebSocketConfig{
+		Encoding:             encoding,
+		EnableCompression:    c.EnableCompression,
+		CompressionThreshold: c.CompressionThreshold,
+		GraceClosePeriod:     c.GraceClosePeriod,
+	}), nil
+}
+
+// closeCode returns the close code and reason the connection ends with after err
+func closeCode(err error) (int, string) {
+	if err == errClosed {
+		return gqlwserror.CloseNormal, err.Error()
+	}
+	ce := gqlwserror.ToCloseError(err)
+	return ce.Code(), ce.Reason()
 }
 
 // returns unsubscribe function
@@ -144,71 +311,129 @@ func (c *Client) Subscribe(payload gqlwsmessage.SubscribePayload, handlers Handl
 		handlers.OnNext = func(r *graphql.Result) {}
 	}
 	id := uuid.NewString()
-	c.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Subscribe, ID: &id, Payload: payload}
-	c.sm.set(id, &handlers)
+	c.sm.set(id, payload, &handlers)
+	c.send(&gqlwsmessage.Message{Type: gqlwsmessage.Subscribe, ID: &id, Payload: payload})
 	return func() {
-		c.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: &id}
+		c.send(&gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: &id})
 		c.sm.del(id)
 	}
 }
 
-func (c *Client) handleResponse(msg *gqlwsmessage.Message) {
-	var err error
-	defer func() {
-		defer goutils.RecoverToErr(new(error))
-		if err != nil {
-			c.breaker <- err
+// Refresh sends new credentials to the server in a ping, replacing those of connection_init before they expire
+func (c *Client) Refresh(credentials interface{}) {
+	c.send(&gqlwsmessage.Message{Type: gqlwsmessage.Ping, Payload: map[string]interface{}{gqlwsmessage.RefreshKey: credentials}})
+}
+
+// send queues msg for the writer unless the client is closed
+func (c *Client) send(msg *gqlwsmessage.Message) {
+	select {
+	case c.writer <- msg:
+	case <-c.done:
+	}
+}
+
+// encoding returns the encoding of the current connection
+func (c *Client) encoding() gqlwsmessage.Encoding {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.transport.Encoding()
+}
+
+// decodePayload replaces the raw payload of msg with its generic form before it is handed to the hooks
+func (c *Client) decodePayload(msg *gqlwsmessage.Message) {
+	if msg.Payload == nil {
+		return
+	}
+	var payload gqlwsmessage.Payload
+	if err := c.encoding().DecodePayload(msg.Payload, &payload); err != nil {
+		panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `invalid message payload`))
+	}
+	msg.Payload = payload
+}
+
+// dispatch runs the handler call f after the previous ones of id. handlers run off the listener so that a slow one
+// holds up neither the other operations nor the pings. a panicking handler ends the connection with fail
+func (c *Client) dispatch(id string, fail func(error), f func()) {
+	c.sm.post(id, func() {
+		if err := goutils.Try(f); err != nil {
+			fail(err)
 		}
-	}()
+	})
+}
+
+// handleResponse validates msg on the listener, so that protocol errors end the connection and results are patched in order
+func (c *Client) handleResponse(msg *gqlwsmessage.Message, fail func(error)) (err error) {
 	defer goutils.RecoverToErr(&err)
+	if msg.Seq > 0 {
+		atomic.StoreUint64(&c.lastSeq, msg.Seq)
+	}
 	switch msg.Type {
 	case gqlwsmessage.ConnectionAck:
-		c.init <- msg
+		if atomic.LoadInt32(&c.inited) == 1 {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `unexpected connection ack`))
+		}
+		select {
+		case c.init <- msg:
+		default:
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `unexpected connection ack`))
+		}
 	case gqlwsmessage.Ping:
-		c.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Pong, Payload: c.OnPing(msg)}
+		c.decodePayload(msg)
+		c.send(&gqlwsmessage.Message{Type: gqlwsmessage.Pong, Payload: c.OnPing(msg)})
 	case gqlwsmessage.Pong:
+		c.decodePayload(msg)
 		c.OnPong(msg)
 	case gqlwsmessage.Next:
 		if msg.ID == nil {
-			panic(gqlwserror.NewFatalError(4400, `next gqlwsmessage must come with id`))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `next gqlwsmessage must come with id`))
 		}
 		hdl := c.sm.get(*msg.ID)
 		if hdl == nil {
-			panic(gqlwserror.NewFatalError(4400, `subscription not found`))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `subscription not found`))
 		}
-		var payload graphql.Result
-		if err := goutils.Try(func() { goutils.UnmarshalJSONFromMap(msg.Payload.(map[string]interface{}), &payload) }); err != nil {
-			panic(gqlwserror.NewFatalError(4400, `payload of next response invalid`))
-		}
-		if payload.Errors != nil {
-			hdl.OnError(payload.Errors)
+		payload := &graphql.Result{}
+		var err error
+		if c.usesPatches() {
+			payload, err = c.patchedResult(*msg.ID, msg.Payload)
 		} else {
-			hdl.OnNext(&payload)
+			err = c.encoding().DecodePayload(msg.Payload, payload)
 		}
+		if err != nil {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `payload of next response invalid`))
+		}
+		c.dispatch(*msg.ID, fail, func() {
+			if payload.Errors != nil {
+				hdl.OnError(payload.Errors)
+			} else {
+				hdl.OnNext(payload)
+			}
+		})
 	case gqlwsmessage.Error:
 		if msg.ID == nil {
-			panic(gqlwserror.NewFatalError(4400, `error gqlwsmessage must come with id`))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `error gqlwsmessage must come with id`))
 		}
 		hdl := c.sm.get(*msg.ID)
 		if hdl == nil {
-			panic(errors.New(`subscription not found`))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `subscription not found`))
 		}
 		payload := gqlerrors.FormattedErrors{}
-		if err := goutils.Try(func() { goutils.UnmarshalJSONFromMap(msg.Payload.(map[string]interface{}), &payload) }); err != nil {
-			panic(errors.New(`payload of error response invalid`))
+		if err := c.encoding().DecodePayload(msg.Payload, &payload); err != nil {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `payload of error response invalid`))
 		}
-		hdl.OnError(payload)
+		c.dispatch(*msg.ID, fail, func() { hdl.OnError(payload) })
+		c.sm.del(*msg.ID)
 	case gqlwsmessage.Complete:
 		if msg.ID == nil {
-			panic(errors.New(`error gqlwsmessage must come with id`))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `error gqlwsmessage must come with id`))
 		}
 		hdl := c.sm.get(*msg.ID)
 		if hdl == nil {
-			panic(errors.New(`subscription not found`))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `subscription not found`))
 		}
-		defer c.sm.del(*msg.ID)
-		hdl.OnComplete()
+		c.dispatch(*msg.ID, fail, hdl.OnComplete)
+		c.sm.del(*msg.ID)
 	default:
-		panic(errors.New(`invalid gqlwsmessage type`))
+		panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `invalid gqlwsmessage type`))
 	}
+	return nil
 }
@@ -1,8 +1,16 @@
 package gqlwsclient_test
 
 import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,8 +18,11 @@ import (
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/graphql/gqlerrors"
 	gqlwsclient "github.com/onichandame/gql-ws/client"
+	gqlwserror "github.com/onichandame/gql-ws/error"
 	gqlwsmessage "github.com/onichandame/gql-ws/message"
 	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,14 +56,20 @@ func TestClient(t *testing.T) {
 							ticker := time.NewTicker(time.Millisecond)
 							res := make(chan interface{})
 							go func() {
+								defer close(res)
+								defer ticker.Stop()
 								for {
 									select {
 									case <-stop:
-										close(res)
+										return
 									case <-p.Context.Done():
-										close(res)
+										return
 									case <-ticker.C:
-										res <- `hi`
+										select {
+										case res <- `hi`:
+										case <-stop:
+											return
+										}
 									}
 								}
 							}()
@@ -108,3 +125,439 @@ func TestClient(t *testing.T) {
 		}
 	})
 }
+
+// frame is a websocket message as it crossed the wire
+type frame struct {
+	opcode byte
+	// set on compressed messages
+	rsv1 bool
+	size int
+}
+
+// parseFrames splits data into messages, joining their continuation frames
+func parseFrames(data []byte) []frame {
+	var frames []frame
+	for len(data) >= 2 {
+		f := frame{opcode: data[0] & 0x0f, rsv1: data[0]&0x40 != 0, size: int(data[1] & 0x7f)}
+		header := 2
+		switch f.size {
+		case 126:
+			f.size, header = int(binary.BigEndian.Uint16(data[2:])), 4
+		case 127:
+			f.size, header = int(binary.BigEndian.Uint64(data[2:])), 10
+		}
+		if data[1]&0x80 != 0 {
+			// masking key
+			header += 4
+		}
+		if len(data) < header+f.size {
+			break
+		}
+		data = data[header+f.size:]
+		if f.opcode == 0 && len(frames) > 0 {
+			frames[len(frames)-1].size += f.size
+			continue
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// recorder keeps the bytes crossing the connection it hijacks
+type recorder struct {
+	http.ResponseWriter
+	lock          sync.Mutex
+	read, written []byte
+}
+
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := r.ResponseWriter.(http.Hijacker).Hijack()
+	return &recordingConn{Conn: conn, r: r}, brw, err
+}
+
+// frames returns the handshake response, then the messages read from and written to the client so far
+func (r *recorder) frames() (string, []frame, []frame) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	end := strings.Index(string(r.written), "\r\n\r\n") + 4
+	return string(r.written[:end]), parseFrames(r.read), parseFrames(r.written[end:])
+}
+
+type recordingConn struct {
+	net.Conn
+	r *recorder
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.r.lock.Lock()
+	defer c.r.lock.Unlock()
+	c.r.read = append(c.r.read, p[:n]...)
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.r.lock.Lock()
+	c.r.written = append(c.r.written, p...)
+	c.r.lock.Unlock()
+	return c.Conn.Write(p)
+}
+
+func TestClientCompression(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"b": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return strings.Repeat(`hi`, 1024), nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	// a repetitive comment, which only the levels looking for matches shrink much
+	padding := strings.Repeat(`padding `, 256)
+	// exchange queries b over a compressed connection. returns the handshake response, then the messages the server read and wrote
+	exchange := func(t *testing.T, level int) (string, []frame, []frame) {
+		recorders := make(chan *recorder, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &recorder{ResponseWriter: w}
+			recorders <- rec
+			gqlwsserver.NewSocket(&gqlwsserver.Config{Response: rec, Request: r, Schema: &schema, EnableCompression: true, CompressionThreshold: 64}).Wait()
+		}))
+		defer srv.Close()
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			URL:                  `ws` + strings.TrimPrefix(srv.URL, `http`),
+			ConnectionAckTimeout: time.Second,
+			EnableCompression:    true,
+			CompressionLevel:     level,
+			CompressionThreshold: 64,
+		})
+		defer client.Close()
+		res := make(chan string)
+		client.Subscribe(gqlwsmessage.SubscribePayload{Query: "query{b}\n#" + padding}, gqlwsclient.Handlers{OnNext: func(r *graphql.Result) { res <- r.Data.(map[string]interface{})[`b`].(string) }, OnError: func(fe gqlerrors.FormattedErrors) { close(res) }})
+		v, ok := <-res
+		assert.True(t, ok)
+		assert.Equal(t, strings.Repeat(`hi`, 1024), v)
+		return (<-recorders).frames()
+	}
+	handshake, read, written := exchange(t, flate.HuffmanOnly)
+	assert.Contains(t, handshake, `permessage-deflate`)
+	if assert.GreaterOrEqual(t, len(read), 2) && assert.GreaterOrEqual(t, len(written), 2) {
+		// connection_init and its ack are below the threshold, the subscribe and its result above it
+		assert.False(t, read[0].rsv1)
+		assert.True(t, read[1].rsv1)
+		assert.False(t, written[0].rsv1)
+		assert.True(t, written[1].rsv1)
+		assert.Less(t, written[1].size, 1024)
+	}
+	_, best, _ := exchange(t, flate.BestCompression)
+	if assert.GreaterOrEqual(t, len(best), 2) && assert.GreaterOrEqual(t, len(read), 2) {
+		assert.Less(t, best[1].size*4, read[1].size)
+	}
+}
+
+func TestClientEncodings(t *testing.T) {
+	srv := gqlwstest.NewServer(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), Encodings: []gqlwsmessage.Encoding{gqlwsmessage.Msgpack, gqlwsmessage.CBOR}})
+	for _, enc := range []gqlwsmessage.Encoding{gqlwsmessage.Msgpack, gqlwsmessage.CBOR} {
+		t.Run(enc.Subprotocol(), func(t *testing.T) {
+			client := gqlwsclient.NewClient(&gqlwsclient.Config{
+				URL:                  srv.WebSocketURL,
+				ConnectionAckTimeout: time.Millisecond * 500,
+				GraceClosePeriod:     time.Millisecond * 500,
+				Encoding:             enc,
+			})
+			defer client.Close()
+			res := make(chan string)
+			client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`}, gqlwsclient.Handlers{OnNext: func(r *graphql.Result) { res <- r.Data.(map[string]interface{})[`s`].(string) }, OnError: func(fe gqlerrors.FormattedErrors) { close(res) }})
+			for i := 0; i < 10; i++ {
+				v, ok := <-res
+				assert.True(t, ok)
+				assert.Equal(t, `hi`, v)
+			}
+		})
+	}
+}
+
+func TestClientInMemory(t *testing.T) {
+	ackTimeout := time.Millisecond * 500
+	schema := gqlwstest.Schema(t)
+	t.Run(`in-memory`, func(t *testing.T) {
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		go func() { gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: schema}).Wait() }()
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			Dial:                 func() (gqlwstransport.Transport, error) { return clientEnd, nil },
+			ConnectionAckTimeout: ackTimeout,
+		})
+		defer client.Close()
+		res := make(chan string)
+		client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`}, gqlwsclient.Handlers{OnNext: func(r *graphql.Result) { res <- r.Data.(map[string]interface{})[`s`].(string) }, OnError: func(fe gqlerrors.FormattedErrors) { close(res) }})
+		for i := 0; i < 10; i++ {
+			v, ok := <-res
+			assert.True(t, ok)
+			assert.Equal(t, `hi`, v)
+		}
+	})
+	t.Run(`slow handler holds up no other operation`, func(t *testing.T) {
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		go func() { gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: schema}).Wait() }()
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			Dial:                 func() (gqlwstransport.Transport, error) { return clientEnd, nil },
+			ConnectionAckTimeout: ackTimeout,
+		})
+		defer client.Close()
+		release := make(chan interface{})
+		defer close(release)
+		client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`}, gqlwsclient.Handlers{OnNext: func(r *graphql.Result) { <-release }})
+		res := make(chan string)
+		client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`}, gqlwsclient.Handlers{OnNext: func(r *graphql.Result) { res <- r.Data.(map[string]interface{})[`s`].(string) }, OnError: func(fe gqlerrors.FormattedErrors) { close(res) }})
+		for i := 0; i < 10; i++ {
+			select {
+			case v, ok := <-res:
+				assert.True(t, ok)
+				assert.Equal(t, `hi`, v)
+			case <-time.After(time.Second):
+				t.Fatal(`held up by the slow handler`)
+			}
+		}
+	})
+	t.Run(`resumes session`, func(t *testing.T) {
+		sessions := gqlwsserver.NewSessions(&gqlwsserver.SessionsConfig{})
+		dropped := make(chan gqlwstransport.Transport, 1)
+		dials := 0
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			Dial: func() (gqlwstransport.Transport, error) {
+				dials++
+				clientEnd, serverEnd := gqlwstransport.Pipe()
+				go func() {
+					gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: schema, Sessions: sessions}).Wait()
+				}()
+				select {
+				case dropped <- clientEnd:
+				default:
+				}
+				return clientEnd, nil
+			},
+			ConnectionAckTimeout: ackTimeout,
+		})
+		defer client.Close()
+		res := make(chan string)
+		client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`}, gqlwsclient.Handlers{OnNext: func(r *graphql.Result) { res <- r.Data.(map[string]interface{})[`s`].(string) }, OnError: func(fe gqlerrors.FormattedErrors) { close(res) }})
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, `hi`, <-res)
+		}
+		(<-dropped).Close(gqlwserror.CloseAbnormal, `dropped`)
+		for i := 0; i < 10; i++ {
+			v, ok := <-res
+			assert.True(t, ok)
+			assert.Equal(t, `hi`, v)
+		}
+		assert.Equal(t, 2, dials)
+		assert.Nil(t, client.Error())
+	})
+	t.Run(`reports close error`, func(t *testing.T) {
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		go func() {
+			gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: schema, OnConnectionInit: func(m *gqlwsmessage.Message) gqlwsmessage.Payload {
+				panic(gqlwserror.ErrUnauthorized)
+			}}).Wait()
+		}()
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			Dial:                 func() (gqlwstransport.Transport, error) { return clientEnd, nil },
+			ConnectionAckTimeout: ackTimeout,
+		})
+		client.Wait()
+		assert.True(t, errors.Is(client.Error(), gqlwserror.ErrUnauthorized))
+	})
+	t.Run(`applies JSON patches`, func(t *testing.T) {
+		counter, err := graphql.NewSchema(graphql.SchemaConfig{
+			Query: graphql.NewObject(graphql.ObjectConfig{Name: `Query`, Fields: graphql.Fields{"q": &graphql.Field{Type: graphql.String}}}),
+			Subscription: graphql.NewObject(graphql.ObjectConfig{
+				Name: `Sub`,
+				Fields: graphql.Fields{
+					"n": &graphql.Field{
+						Type: graphql.NewList(graphql.Int),
+						Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+							return []int{0, p.Source.(int)}, nil
+						},
+						Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+							res := make(chan interface{})
+							go func() {
+								defer close(res)
+								for i := 0; i < 3; i++ {
+									res <- i
+								}
+							}()
+							return res, nil
+						},
+					},
+				},
+			}),
+		})
+		assert.Nil(t, err)
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		go func() {
+			gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: &counter, EnableJSONPatch: true}).Wait()
+		}()
+		patched := make(chan bool, 1)
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			Dial:                 func() (gqlwstransport.Transport, error) { return clientEnd, nil },
+			ConnectionAckTimeout: ackTimeout,
+			EnableJSONPatch:      true,
+			OnConnected: func(m *gqlwsmessage.Message) {
+				patched <- m.Payload.(map[string]interface{})[gqlwsmessage.JSONPatchKey] == true
+			},
+		})
+		defer client.Close()
+		assert.True(t, <-patched)
+		res := make(chan interface{})
+		client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{n}`}, gqlwsclient.Handlers{
+			OnNext:     func(r *graphql.Result) { res <- r.Data.(map[string]interface{})[`n`] },
+			OnComplete: func() { close(res) },
+		})
+		var results []interface{}
+		for r := range res {
+			results = append(results, r)
+		}
+		assert.Equal(t, []interface{}{[]interface{}{0.0, 0.0}, []interface{}{0.0, 1.0}, []interface{}{0.0, 2.0}}, results)
+	})
+	t.Run(`refreshes credentials`, func(t *testing.T) {
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		refreshed := make(chan interface{}, 1)
+		go func() {
+			gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: schema,
+				OnConnectionInit: func(m *gqlwsmessage.Message) gqlwsmessage.Payload {
+					return gqlwsserver.ExpiresAt(nil, time.Now().Add(ackTimeout))
+				},
+				OnRefresh: func(m *gqlwsmessage.Message) (time.Time, error) {
+					refreshed <- m.Payload.(map[string]interface{})[gqlwsmessage.RefreshKey]
+					return time.Now().Add(time.Hour), nil
+				},
+			}).Wait()
+		}()
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			Dial:                 func() (gqlwstransport.Transport, error) { return clientEnd, nil },
+			ConnectionAckTimeout: ackTimeout,
+		})
+		defer client.Close()
+		client.Refresh(`token`)
+		assert.Equal(t, `token`, <-refreshed)
+		time.Sleep(ackTimeout * 2)
+		assert.Nil(t, client.Error())
+	})
+}
+
+func TestClientReconnect(t *testing.T) {
+	ackTimeout := time.Millisecond * 500
+	quiet := time.Millisecond * 100
+	// reads the next message or returns nil once the client has been quiet for a while
+	read := func(t *testing.T, tr gqlwstransport.Transport) *gqlwsmessage.Message {
+		msgs := make(chan *gqlwsmessage.Message, 1)
+		go func() {
+			msg, _ := tr.ReadMessage()
+			msgs <- msg
+		}()
+		select {
+		case msg := <-msgs:
+			return msg
+		case <-time.After(quiet):
+			return nil
+		}
+	}
+	// acks the connection init with a session the client reconnects to, which the server never resumes
+	accept := func(t *testing.T, tr gqlwstransport.Transport) {
+		msg, err := tr.ReadMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, gqlwsmessage.ConnectionInit, msg.Type)
+		assert.Nil(t, tr.WriteMessage(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionAck, Payload: map[string]interface{}{gqlwsmessage.SessionTokenKey: `session`}}))
+	}
+	// dials in-memory connections whose server end the test speaks the protocol on. returns the first one, accepted
+	dial := func(t *testing.T) (*gqlwsclient.Client, gqlwstransport.Transport, chan gqlwstransport.Transport) {
+		servers := make(chan gqlwstransport.Transport, 1)
+		first := make(chan gqlwstransport.Transport)
+		// NewClient returns once the first connection is acknowledged
+		go func() {
+			server := <-servers
+			accept(t, server)
+			first <- server
+		}()
+		client := gqlwsclient.NewClient(&gqlwsclient.Config{
+			Dial: func() (gqlwstransport.Transport, error) {
+				clientEnd, serverEnd := gqlwstransport.Pipe()
+				servers <- serverEnd
+				return clientEnd, nil
+			},
+			ConnectionAckTimeout: ackTimeout,
+		})
+		return client, <-first, servers
+	}
+	// returns the queries of the subscribe messages the client sends until it is quiet
+	subscribes := func(t *testing.T, tr gqlwstransport.Transport) map[string]string {
+		queries := map[string]string{}
+		for msg := read(t, tr); msg != nil; msg = read(t, tr) {
+			if msg.Type != gqlwsmessage.Subscribe {
+				continue
+			}
+			var payload gqlwsmessage.SubscribePayload
+			assert.Nil(t, tr.Encoding().DecodePayload(msg.Payload, &payload))
+			_, dup := queries[*msg.ID]
+			assert.False(t, dup, `subscribed twice`)
+			queries[*msg.ID] = payload.Query
+		}
+		return queries
+	}
+	t.Run(`forgets an operation that failed`, func(t *testing.T) {
+		client, server, servers := dial(t)
+		defer client.Close()
+		failed := make(chan interface{})
+		client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`}, gqlwsclient.Handlers{OnError: func(fe gqlerrors.FormattedErrors) { close(failed) }})
+		msg, err := server.ReadMessage()
+		assert.Nil(t, err)
+		assert.Nil(t, server.WriteMessage(&gqlwsmessage.Message{Type: gqlwsmessage.Error, ID: msg.ID, Payload: gqlerrors.FormatErrors(errors.New(`failed`))}))
+		<-failed
+		server.Close(gqlwserror.CloseAbnormal, `dropped`)
+		server = <-servers
+		accept(t, server)
+		assert.Empty(t, subscribes(t, server))
+		assert.Nil(t, client.Error())
+	})
+	t.Run(`fails the queries and resubscribes the subscriptions`, func(t *testing.T) {
+		client, server, servers := dial(t)
+		defer client.Close()
+		failed := make(chan gqlerrors.FormattedErrors, 1)
+		// the subscribe messages go out as the server reads them
+		go client.Subscribe(gqlwsmessage.SubscribePayload{Query: `query{q}`}, gqlwsclient.Handlers{OnError: func(fe gqlerrors.FormattedErrors) { failed <- fe }})
+		go client.Subscribe(gqlwsmessage.SubscribePayload{Query: `mutation A{m} subscription B{s}`, OperationName: `B`}, gqlwsclient.Handlers{OnError: func(fe gqlerrors.FormattedErrors) { t.Error(`subscription failed`) }})
+		assert.Len(t, subscribes(t, server), 2)
+		server.Close(gqlwserror.CloseAbnormal, `dropped`)
+		server = <-servers
+		accept(t, server)
+		queries := subscribes(t, server)
+		assert.Len(t, queries, 1)
+		for _, query := range queries {
+			assert.Equal(t, `mutation A{m} subscription B{s}`, query)
+		}
+		select {
+		case fe := <-failed:
+			assert.Len(t, fe, 1)
+		case <-time.After(time.Second):
+			t.Fatal(`query not failed`)
+		}
+		assert.Nil(t, client.Error())
+	})
+	t.Run(`subscribes once while reconnecting`, func(t *testing.T) {
+		client, server, servers := dial(t)
+		defer client.Close()
+		server.Close(gqlwserror.CloseAbnormal, `dropped`)
+		server = <-servers
+		// queued before the new connection is acknowledged, so that the resubscription also finds it
+		go client.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`}, gqlwsclient.Handlers{})
+		time.Sleep(quiet)
+		accept(t, server)
+		assert.Len(t, subscribes(t, server), 1)
+		assert.Nil(t, client.Error())
+	})
+}
@@ -7,27 +7,49 @@ import (
 	"time"
 
 	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
 )
 
 type Config struct {
-	URL                  string
+	URL string
+	// Dial replaces dialing URL, e.g. to connect to an end of gqlwstransport.Pipe
+	Dial func() (gqlwstransport.Transport, error)
+
 	ConnectionAckTimeout time.Duration
 	GraceClosePeriod     time.Duration
-	// maximum retry attempts before a connection is established
+	// Codec encodes the JSON subprotocol. defaults to encoding/json
+	Codec gqlwsmessage.Codec
+	// Encoding is preferred over JSON when the server supports it
+	Encoding gqlwsmessage.Encoding
+	// EnableCompression offers permessage-deflate to the server
+	EnableCompression bool
+	// CompressionLevel is the flate level of compressed messages. 0 keeps the websocket default
+	CompressionLevel int
+	// CompressionThreshold is the minimum encoded size in bytes of a message to be compressed
+	CompressionThreshold int
+	// EnableJSONPatch asks the server to send the results following the first of an operation as JSON patches,
+	// which are applied before OnNext is called
+	EnableJSONPatch bool
+	// maximum retry attempts before a connection is established, also applying to reconnects after a drop
 	ReconnectAttempts uint32
+	// ReconnectInterval is the pause between attempts
+	ReconnectInterval time.Duration
 	// OnConnecting called on connection init
 	// returns the payload to send in the init request
-	OnConnecting        func() interface{}
+	OnConnecting func() interface{}
+	// the hooks and handlers may panic with a *gqlwserror.CloseError to close the connection with its code
 	OnPing              func(*gqlwsmessage.Message) interface{}
 	OnPong, OnConnected func(*gqlwsmessage.Message)
 }
 
 func (c *Config) init() {
-	if u, err := url.Parse(c.URL); err != nil {
-		panic(err)
-	} else {
-		if !strings.HasPrefix(u.Scheme, "ws") {
-			panic(errors.New(`gql-ws must be configured to a websocket endpoint`))
+	if c.Dial == nil {
+		if u, err := url.Parse(c.URL); err != nil {
+			panic(err)
+		} else {
+			if !strings.HasPrefix(u.Scheme, "ws") {
+				panic(errors.New(`gql-ws must be configured to a websocket endpoint`))
+			}
 		}
 	}
 	if c.ConnectionAckTimeout <= 0 {
@@ -36,6 +58,15 @@ func (c *Config) init() {
 	if c.GraceClosePeriod <= 0 {
 		c.GraceClosePeriod = time.Second * 5
 	}
+	if c.Codec == nil {
+		c.Codec = gqlwsmessage.StdCodec{}
+	}
+	if c.Encoding == nil {
+		c.Encoding = gqlwsmessage.NewJSONEncoding(c.Codec)
+	}
+	if c.CompressionThreshold < 0 {
+		c.CompressionThreshold = 0
+	}
 	if c.OnConnecting == nil {
 		c.OnConnecting = func() interface{} { return nil }
 	}
@@ -0,0 +1,59 @@
+package gqlwsclient_test
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+
+	gqlwsclient "github.com/onichandame/gql-ws/client"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
+)
+
+// FuzzClient feeds newline separated frames to a client. the client must end without leaking goroutines
+// and close with a code of the protocol
+func FuzzClient(f *testing.F) {
+	sanctioned := map[int]bool{gqlwserror.CloseNormal: true, gqlwserror.CloseBadRequest: true, gqlwserror.CloseAcknowledgementTimeout: true}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		goroutines := runtime.NumGoroutine()
+		tr := gqlwstest.NewFrameTransport(bytes.Split(data, []byte("\n")))
+		client := make(chan *gqlwsclient.Client)
+		go func() {
+			client <- gqlwsclient.NewClient(&gqlwsclient.Config{
+				Dial:                 func() (gqlwstransport.Transport, error) { return tr, nil },
+				ConnectionAckTimeout: time.Millisecond * 10,
+			})
+		}()
+		var c *gqlwsclient.Client
+		select {
+		case c = <-client:
+		case <-time.After(time.Second * 5):
+			t.Fatal(`deadlocked on init`)
+		}
+		time.Sleep(time.Millisecond)
+		c.Close()
+		done := make(chan interface{})
+		go func() {
+			c.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			t.Fatal(`deadlocked on close`)
+		}
+		if !sanctioned[tr.Code()] {
+			t.Fatalf(`closed with %v: %v`, tr.Code(), c.Error())
+		}
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > goroutines {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("leaked %v goroutines\n%s", runtime.NumGoroutine()-goroutines, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
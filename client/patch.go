@@ -0,0 +1,64 @@
+package gqlwsclient
+
+import (
+	"errors"
+
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// usesPatches reports whether the server confirmed that results are sent as JSON patches
+func (c *Client) usesPatches() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.patches
+}
+
+// acceptPatches keeps whether the ack payload confirms the JSON patches asked for
+func (c *Client) acceptPatches(payload gqlwsmessage.Payload) {
+	p, _ := payload.(map[string]interface{})
+	accepted, _ := p[gqlwsmessage.JSONPatchKey].(bool)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.patches = c.EnableJSONPatch && accepted
+}
+
+// patchedResult decodes the result of a next message of id, applying the patch it carries to the previous result
+func (c *Client) patchedResult(id string, payload gqlwsmessage.Payload) (*graphql.Result, error) {
+	var doc interface{}
+	if err := c.encoding().DecodePayload(payload, &doc); err != nil {
+		return nil, err
+	}
+	if obj, ok := doc.(map[string]interface{}); ok {
+		if raw, ok := obj[gqlwsmessage.PatchKey]; ok {
+			var patch []gqlwsmessage.PatchOperation
+			if err := c.convert(raw, &patch); err != nil {
+				return nil, err
+			}
+			prev := c.sm.result(id)
+			if prev == nil {
+				return nil, errors.New(`patch without a previous result`)
+			}
+			patched, err := gqlwsmessage.ApplyPatch(prev, patch)
+			if err != nil {
+				return nil, err
+			}
+			doc = patched
+		}
+	}
+	c.sm.setResult(id, doc)
+	var res graphql.Result
+	if err := c.convert(doc, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// convert decodes the generic form src into dst
+func (c *Client) convert(src, dst interface{}) error {
+	data, err := c.Codec.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return c.Codec.Unmarshal(data, dst)
+}
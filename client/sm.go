@@ -6,32 +6,149 @@ import (
 
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
 )
 
 type subMan struct {
-	subs map[string]*Handlers
+	subs map[string]*subscription
 	lock sync.RWMutex
 }
 
+type subscription struct {
+	payload  gqlwsmessage.SubscribePayload
+	handlers *Handlers
+	// whether it is a subscription, which is resent on reconnect. queries and mutations fail instead
+	resubscribe bool
+	// the connection its subscribe message was last written to
+	conn uint64
+	// the last result in its generic form, which JSON patches apply to
+	result interface{}
+	// runs the handler calls in order
+	mailbox mailbox
+}
+
+// mailbox runs the functions posted to it one after the other on a goroutine of its own, which exits once they are drained
+type mailbox struct {
+	lock    sync.Mutex
+	queue   []func()
+	running bool
+}
+
+func (mb *mailbox) post(f func()) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.queue = append(mb.queue, f)
+	if !mb.running {
+		mb.running = true
+		go mb.run()
+	}
+}
+
+func (mb *mailbox) run() {
+	for {
+		mb.lock.Lock()
+		if len(mb.queue) == 0 {
+			mb.running = false
+			mb.lock.Unlock()
+			return
+		}
+		f := mb.queue[0]
+		mb.queue = mb.queue[1:]
+		mb.lock.Unlock()
+		f()
+	}
+}
+
 func newSubMan() *subMan {
 	var sm subMan
-	sm.subs = make(map[string]*Handlers)
+	sm.subs = make(map[string]*subscription)
 	return &sm
 }
 
-func (sm *subMan) set(id string, hdl *Handlers) {
+func (sm *subMan) set(id string, payload gqlwsmessage.SubscribePayload, hdl *Handlers) {
 	sm.lock.Lock()
 	defer sm.lock.Unlock()
 	if _, ok := sm.subs[id]; ok {
 		panic(errors.New(`subscription already present`))
 	}
-	sm.subs[id] = hdl
+	sm.subs[id] = &subscription{payload: payload, handlers: hdl, resubscribe: isSubscription(payload)}
+}
+
+// claim reports whether the subscribe message of id is due on connection conn, marking it as written there. the
+// message is queued by Subscribe and again by the resubscription on reconnect, so it must go out once per connection
+func (sm *subMan) claim(id string, conn uint64) bool {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sub := sm.subs[id]
+	if sub == nil || sub.conn == conn {
+		return false
+	}
+	sub.conn = conn
+	return true
 }
 
 func (sm *subMan) get(id string) *Handlers {
 	sm.lock.RLock()
 	defer sm.lock.RUnlock()
-	return sm.subs[id]
+	if sub := sm.subs[id]; sub != nil {
+		return sub.handlers
+	}
+	return nil
+}
+
+// post queues f on the mailbox of id. it is dropped if id is not running
+func (sm *subMan) post(id string, f func()) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	if sub := sm.subs[id]; sub != nil {
+		sub.mailbox.post(f)
+	}
+}
+
+func (sm *subMan) result(id string) interface{} {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	if sub := sm.subs[id]; sub != nil {
+		return sub.result
+	}
+	return nil
+}
+
+func (sm *subMan) setResult(id string, result interface{}) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if sub := sm.subs[id]; sub != nil {
+		sub.result = result
+	}
+}
+
+// payloads returns the payloads of the running subscriptions by id
+func (sm *subMan) payloads() map[string]gqlwsmessage.SubscribePayload {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	payloads := make(map[string]gqlwsmessage.SubscribePayload, len(sm.subs))
+	for id, sub := range sm.subs {
+		if sub.resubscribe {
+			payloads[id] = sub.payload
+		}
+	}
+	return payloads
+}
+
+// transient returns the handlers of the running queries and mutations by id
+func (sm *subMan) transient() map[string]*Handlers {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	handlers := make(map[string]*Handlers)
+	for id, sub := range sm.subs {
+		if !sub.resubscribe {
+			handlers[id] = sub.handlers
+		}
+	}
+	return handlers
 }
 
 func (sm *subMan) del(id string) {
@@ -40,6 +157,30 @@ func (sm *subMan) del(id string) {
 	delete(sm.subs, id)
 }
 
+// isSubscription reports whether the operation of payload that the server selects is a subscription
+func isSubscription(payload gqlwsmessage.SubscribePayload) bool {
+	AST, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(payload.Query), Name: `GraphQL request`})})
+	if err != nil {
+		return false
+	}
+	var selected *ast.OperationDefinition
+	for _, node := range AST.Definitions {
+		op, ok := node.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if payload.OperationName == `` {
+			if selected != nil {
+				return false
+			}
+			selected = op
+		} else if op.Name != nil && op.Name.Value == payload.OperationName {
+			selected = op
+		}
+	}
+	return selected != nil && selected.Operation == ast.OperationTypeSubscription
+}
+
 type Handlers struct {
 	OnError    func(gqlerrors.FormattedErrors)
 	OnComplete func()
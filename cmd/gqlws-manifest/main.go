@@ -0,0 +1,78 @@
+// gqlws-manifest generates the manifest of trusted documents loaded by gqlwsserver.LoadManifest.
+// every .graphql or .gql file under the given paths is a document, registered under the SHA-256 of its source
+//
+//	gqlws-manifest -o manifest.json ./src/graphql
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+)
+
+func main() {
+	output := flag.String(`o`, ``, `file to write the manifest to. defaults to stdout`)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [-o manifest.json] path...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	manifest, err := generate(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(manifest, ``, `  `)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+	if *output == `` {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := ioutil.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// generate registers the documents found under paths, failing on any that does not parse
+func generate(paths []string) (gqlwsserver.Manifest, error) {
+	manifest := gqlwsserver.Manifest{}
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			if ext := filepath.Ext(path); ext != `.graphql` && ext != `.gql` {
+				return nil
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if _, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: data, Name: path})}); err != nil {
+				return fmt.Errorf(`%v: %v`, path, err)
+			}
+			manifest.Add(string(data))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Run("registers documents", func(t *testing.T) {
+		manifest, err := generate([]string{`testdata`})
+		assert.Nil(t, err)
+		assert.Len(t, manifest, 2)
+		greeting := "query Greeting {\n  q\n}\n"
+		doc, ok := manifest.Document(gqlwsserver.DocumentID(greeting))
+		assert.True(t, ok)
+		assert.Equal(t, greeting, doc)
+	})
+	t.Run("rejects invalid documents", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, `broken.graphql`), []byte(`query {`), 0644))
+		_, err := generate([]string{dir})
+		assert.NotNil(t, err)
+	})
+}
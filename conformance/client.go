@@ -0,0 +1,163 @@
+package gqlwsconformance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	gqlwsclient "github.com/onichandame/gql-ws/client"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
+)
+
+// clientConn is a client under test and the scripted server it is connected to
+type clientConn struct {
+	client *gqlwsclient.Client
+	server *gqlwstest.Conn
+}
+
+type clientCase struct {
+	name string
+	// unacked leaves the client waiting for the acknowledgement
+	unacked bool
+	run     func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn)
+}
+
+var clientCases = []clientCase{
+	{
+		name: `sends connection init first`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			assertNil(t, conn.client.Error())
+		},
+	},
+	{
+		name:    `closes with 4504 without acknowledgement`,
+		unacked: true,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			conn.server.Timeout = cfg.ConnectionAckTimeout*2 + time.Second
+			conn.server.ExpectClose(gqlwserror.CloseAcknowledgementTimeout)
+		},
+	},
+	{
+		name: `answers ping with pong`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			conn.server.Ping(nil)
+			conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		},
+	},
+	{
+		name: `ignores unsolicited pong`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			conn.server.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Pong})
+			conn.server.Ping(nil)
+			conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		},
+	},
+	{
+		name: `closes with 4400 on an unknown message type`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			conn.server.Send(&gqlwsmessage.Message{Type: `unknown`})
+			conn.server.ExpectClose(gqlwserror.CloseBadRequest)
+		},
+	},
+	{
+		name: `subscribes with unique ids`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			conn.client.Subscribe(gqlwsmessage.SubscribePayload{Query: `{__typename}`}, gqlwsclient.Handlers{})
+			conn.client.Subscribe(gqlwsmessage.SubscribePayload{Query: `{__typename}`}, gqlwsclient.Handlers{})
+			a := conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Subscribe))
+			b := conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Subscribe))
+			if a.ID == nil || b.ID == nil || *a.ID == *b.ID {
+				t.Fatalf(`expected unique ids`)
+			}
+		},
+	},
+	{
+		name: `delivers events until complete`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			events := make(chan string, 3)
+			conn.client.Subscribe(gqlwsmessage.SubscribePayload{Query: `{__typename}`}, gqlwsclient.Handlers{
+				OnNext:     func(r *graphql.Result) { events <- string(gqlwsmessage.Next) },
+				OnError:    func(gqlerrors.FormattedErrors) { events <- string(gqlwsmessage.Error) },
+				OnComplete: func() { events <- string(gqlwsmessage.Complete) },
+			})
+			id := *conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Subscribe)).ID
+			conn.server.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Next, ID: &id, Payload: &graphql.Result{Data: map[string]interface{}{"__typename": `Query`}}})
+			conn.server.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: &id})
+			for _, expected := range []gqlwsmessage.Type{gqlwsmessage.Next, gqlwsmessage.Complete} {
+				select {
+				case ev := <-events:
+					if ev != string(expected) {
+						t.Fatalf(`expected %v, got %v`, expected, ev)
+					}
+				case <-time.After(conn.server.Timeout):
+					t.Fatalf(`expected %v`, expected)
+				}
+			}
+		},
+	},
+	{
+		name: `delivers errors`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			errs := make(chan gqlerrors.FormattedErrors, 1)
+			conn.client.Subscribe(gqlwsmessage.SubscribePayload{Query: `{__typename}`}, gqlwsclient.Handlers{
+				OnError: func(fe gqlerrors.FormattedErrors) { errs <- fe },
+			})
+			id := *conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Subscribe)).ID
+			conn.server.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Error, ID: &id, Payload: gqlerrors.FormattedErrors{{Message: `oops`}}})
+			select {
+			case fe := <-errs:
+				if len(fe) != 1 || fe[0].Message != `oops` {
+					t.Fatalf(`unexpected errors %v`, fe)
+				}
+			case <-time.After(conn.server.Timeout):
+				t.Fatalf(`expected errors`)
+			}
+		},
+	},
+	{
+		name: `sends complete on unsubscribe`,
+		run: func(t *testing.T, cfg *gqlwsclient.Config, conn *clientConn) {
+			stop := conn.client.Subscribe(gqlwsmessage.SubscribePayload{Query: `{__typename}`}, gqlwsclient.Handlers{})
+			id := *conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Subscribe)).ID
+			stop()
+			conn.server.Expect(gqlwstest.OfType(gqlwsmessage.Complete), gqlwstest.WithID(id))
+		},
+	},
+}
+
+// RunClient runs every case against clients built from cfg, each connected to a scripted server in-memory
+func RunClient(t *testing.T, cfg gqlwsclient.Config) {
+	if cfg.ConnectionAckTimeout <= 0 {
+		cfg.ConnectionAckTimeout = time.Millisecond * 500
+	}
+	for _, c := range clientCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			cfg := cfg
+			clientEnd, serverEnd := gqlwstransport.Pipe()
+			cfg.Dial = func() (gqlwstransport.Transport, error) { return clientEnd, nil }
+			var conn clientConn
+			conn.server = gqlwstest.NewConn(t, serverEnd)
+			client := make(chan *gqlwsclient.Client, 1)
+			go func() { client <- gqlwsclient.NewClient(&cfg) }()
+			conn.server.Expect(gqlwstest.OfType(gqlwsmessage.ConnectionInit))
+			if !c.unacked {
+				conn.server.Send(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionAck})
+				conn.client = <-client
+				defer conn.client.Close()
+			}
+			c.run(t, &cfg, &conn)
+		})
+	}
+}
+
+func assertNil(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package gqlwsconformance_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	gqlwsclient "github.com/onichandame/gql-ws/client"
+	gqlwsconformance "github.com/onichandame/gql-ws/conformance"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+)
+
+func TestServer(t *testing.T) {
+	cfg := &gqlwsserver.Config{Schema: gqlwstest.Schema(t), ConnectionInitTimeout: time.Millisecond * 200}
+	srv := gqlwstest.NewServer(t, cfg)
+	gqlwsconformance.RunServer(t, &gqlwsconformance.Server{
+		Dial:         srv.Dial,
+		Subscription: gqlwsmessage.SubscribePayload{Query: `subscription{s}`},
+		InitTimeout:  cfg.ConnectionInitTimeout,
+	})
+}
+
+func TestServerInMemory(t *testing.T) {
+	gqlwsconformance.RunServer(t, &gqlwsconformance.Server{
+		Dial: func(t testing.TB) *gqlwstest.Conn {
+			return gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), ConnectionInitTimeout: time.Millisecond * 200})
+		},
+		Subscription: gqlwsmessage.SubscribePayload{Query: `subscription{s}`},
+		InitTimeout:  time.Millisecond * 200,
+	})
+}
+
+// TestURL validates the server at GQLWS_CONFORMANCE_URL.
+// GQLWS_CONFORMANCE_SUBSCRIPTION and GQLWS_CONFORMANCE_INIT_TIMEOUT enable the cases needing them
+func TestURL(t *testing.T) {
+	url := os.Getenv(`GQLWS_CONFORMANCE_URL`)
+	if url == `` {
+		t.Skip(`GQLWS_CONFORMANCE_URL is not set`)
+	}
+	timeout, _ := time.ParseDuration(os.Getenv(`GQLWS_CONFORMANCE_INIT_TIMEOUT`))
+	gqlwsconformance.RunServer(t, &gqlwsconformance.Server{
+		Dial:         gqlwsconformance.URL(url),
+		Subscription: gqlwsmessage.SubscribePayload{Query: os.Getenv(`GQLWS_CONFORMANCE_SUBSCRIPTION`)},
+		InitTimeout:  timeout,
+	})
+}
+
+func TestClient(t *testing.T) {
+	gqlwsconformance.RunClient(t, gqlwsclient.Config{ConnectionAckTimeout: time.Millisecond * 200})
+}
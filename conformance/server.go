@@ -0,0 +1,224 @@
+package gqlwsconformance
+
+import (
+	"testing"
+	"time"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+)
+
+// Server is a graphql-transport-ws server under test
+type Server struct {
+	// Dial opens a connection to the server
+	Dial func(t testing.TB) *gqlwstest.Conn
+	// Query is an operation resolving to a single result. defaults to {__typename}
+	Query gqlwsmessage.SubscribePayload
+	// Subscription is an operation emitting results until completed. the cases needing it are skipped when it is empty
+	Subscription gqlwsmessage.SubscribePayload
+	// InitTimeout is the connection initialisation timeout of the server. the timeout case is skipped when it is zero
+	InitTimeout time.Duration
+}
+
+// URL dials the websocket endpoint at url
+func URL(url string) func(t testing.TB) *gqlwstest.Conn {
+	return func(t testing.TB) *gqlwstest.Conn { return gqlwstest.Dial(t, url) }
+}
+
+const (
+	// completeRounds bounds the pings answered with results in between once an operation is completed
+	completeRounds = 10
+	// completeQuietPeriod is how long a completed operation is watched for results
+	completeQuietPeriod = time.Millisecond * 50
+)
+
+type serverCase struct {
+	name string
+	// needs skips the case unless the server provides it
+	needs func(s *Server) bool
+	run   func(t *testing.T, s *Server)
+}
+
+var serverCases = []serverCase{
+	{
+		name: `acknowledges connection init`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+		},
+	},
+	{
+		name:  `closes with 4408 without connection init`,
+		needs: func(s *Server) bool { return s.InitTimeout > 0 },
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Timeout = s.InitTimeout*2 + time.Second
+			conn.ExpectClose(gqlwserror.CloseInitTimeout)
+		},
+	},
+	{
+		name: `closes with 4429 on a second connection init`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			conn.Send(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit})
+			conn.ExpectClose(gqlwserror.CloseTooManyInitRequests)
+		},
+	},
+	{
+		name: `closes with 4401 on subscribe before acknowledgement`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Subscribe(s.Query)
+			conn.ExpectClose(gqlwserror.CloseUnauthorized)
+		},
+	},
+	{
+		name:  `closes with 4409 on a duplicate operation id`,
+		needs: func(s *Server) bool { return s.Subscription.Query != `` },
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			id := conn.Subscribe(s.Subscription)
+			conn.SubscribeWithID(id, s.Subscription)
+			expectClose(t, conn, gqlwserror.CloseSubscriberExists)
+		},
+	},
+	{
+		name: `closes with 4400 on an unknown message type`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			conn.Send(&gqlwsmessage.Message{Type: `unknown`})
+			conn.ExpectClose(gqlwserror.CloseBadRequest)
+		},
+	},
+	{
+		name: `closes with 4400 on subscribe without id`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			conn.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Subscribe, Payload: s.Query})
+			conn.ExpectClose(gqlwserror.CloseBadRequest)
+		},
+	},
+	{
+		name: `answers ping with pong before connection init`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Ping(nil)
+			conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		},
+	},
+	{
+		name: `answers ping with pong`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			conn.Ping(nil)
+			conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		},
+	},
+	{
+		name: `ignores unsolicited pong`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			conn.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Pong})
+			conn.Ping(nil)
+			conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		},
+	},
+	{
+		name: `completes a single result operation`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			id := conn.Subscribe(s.Query)
+			conn.ExpectNext(id)
+			conn.ExpectComplete(id)
+		},
+	},
+	{
+		name: `allows an id to be reused once completed`,
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			id := conn.Subscribe(s.Query)
+			conn.ExpectNext(id)
+			conn.ExpectComplete(id)
+			conn.SubscribeWithID(id, s.Query)
+			conn.ExpectNext(id)
+			conn.ExpectComplete(id)
+		},
+	},
+	{
+		name:  `stops sending events once the client completes`,
+		needs: func(s *Server) bool { return s.Subscription.Query != `` },
+		run: func(t *testing.T, s *Server) {
+			conn := s.Dial(t)
+			conn.Init(nil)
+			id := conn.Subscribe(s.Subscription)
+			conn.ExpectNext(id)
+			conn.Complete(id)
+			// round pings until one is answered without results in between. a server may answer a ping
+			// before it has processed the complete, so results sent until then may still be in flight
+			round := func() int {
+				conn.Ping(nil)
+				nexts := 0
+				for {
+					msg := conn.Expect()
+					if msg.Type == gqlwsmessage.Pong {
+						return nexts
+					}
+					if msg.Type != gqlwsmessage.Next {
+						t.Fatalf(`unexpected %v after complete`, msg.Type)
+					}
+					nexts++
+				}
+			}
+			for i := 0; round() > 0; i++ {
+				if i == completeRounds {
+					t.Fatalf(`still receiving results after %v pings`, completeRounds)
+				}
+			}
+			// the complete has been processed, no result follows
+			time.Sleep(completeQuietPeriod)
+			if n := round(); n > 0 {
+				t.Fatalf(`received %v results after complete`, n)
+			}
+		},
+	},
+}
+
+// RunServer runs every case against s
+func RunServer(t *testing.T, s *Server) {
+	if s.Query.Query == `` {
+		s.Query.Query = `{__typename}`
+	}
+	for _, c := range serverCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if c.needs != nil && !c.needs(s) {
+				t.Skip(`not provided by the server`)
+			}
+			c.run(t, s)
+		})
+	}
+}
+
+// expectClose expects the connection to end with code, ignoring the events of operations still running
+func expectClose(t *testing.T, conn *gqlwstest.Conn, code int) {
+	t.Helper()
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			break
+		}
+		if msg.Type != gqlwsmessage.Next && msg.Type != gqlwsmessage.Complete {
+			t.Fatalf(`expected close %v, got %v`, code, msg.Type)
+		}
+	}
+	conn.ExpectClose(code)
+}
@@ -0,0 +1,114 @@
+package gqlwserror
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// close codes of graphql-transport-ws, plus the ones this module adds
+const (
+	CloseNormal = 1000
+	// CloseGoingAway is sent by a peer shutting down. a client may resume its session after it
+	CloseGoingAway = 1001
+	// CloseAbnormal is reported when a connection drops without a close frame
+	CloseAbnormal         = 1006
+	CloseBadRequest       = 4400
+	CloseUnauthorized     = 4401
+	CloseForbidden        = 4403
+	CloseInitTimeout      = 4408
+	CloseSubscriberExists = 4409
+	// CloseIdleTimeout is sent by a socket that had no operations for its idle timeout
+	CloseIdleTimeout            = 4410
+	CloseTooManyInitRequests    = 4429
+	CloseInternalServerError    = 4500
+	CloseSlowConsumer           = 4503
+	CloseAcknowledgementTimeout = 4504
+)
+
+// sentinels to match a CloseError against with errors.Is, which compares codes only
+var (
+	ErrBadRequest             = NewCloseError(CloseBadRequest, `Bad request`)
+	ErrUnauthorized           = NewCloseError(CloseUnauthorized, `Unauthorized`)
+	ErrForbidden              = NewCloseError(CloseForbidden, `Forbidden`)
+	ErrInitTimeout            = NewCloseError(CloseInitTimeout, `Connection initialisation timeout`)
+	ErrSubscriberExists       = NewCloseError(CloseSubscriberExists, `Subscriber already exists`)
+	ErrIdleTimeout            = NewCloseError(CloseIdleTimeout, `Idle timeout`)
+	ErrTooManyInitRequests    = NewCloseError(CloseTooManyInitRequests, `Too many initialisation requests`)
+	ErrInternalServerError    = NewCloseError(CloseInternalServerError, `Internal server error`)
+	ErrSlowConsumer           = NewCloseError(CloseSlowConsumer, `Slow consumer`)
+	ErrAcknowledgementTimeout = NewCloseError(CloseAcknowledgementTimeout, `Connection acknowledgement timeout`)
+)
+
+// CloseError ends a connection with a close code and reason. it is what both the socket and the client
+// report once the peer closes, and what hooks and resolvers return or panic with to close the connection themselves
+type CloseError struct {
+	code   int
+	reason string
+}
+
+// FatalError is the former name of CloseError
+type FatalError = CloseError
+
+func NewCloseError(code int, reason string) *CloseError {
+	var err CloseError
+	err.code = code
+	err.reason = reason
+	return &err
+}
+
+// NewFatalError is the former name of NewCloseError
+func NewFatalError(code int, reason string) *CloseError { return NewCloseError(code, reason) }
+
+func (e *CloseError) Error() string {
+	if e.reason == `` {
+		return fmt.Sprintf(`connection closed with %v`, e.code)
+	}
+	return fmt.Sprintf(`connection closed with %v: %v`, e.code, e.reason)
+}
+
+func (e *CloseError) Code() int { return e.code }
+
+func (e *CloseError) Reason() string { return e.reason }
+
+// Is matches any CloseError of the same code, so that errors.Is(err, ErrUnauthorized) holds whatever the reason
+func (e *CloseError) Is(target error) bool {
+	t, ok := target.(*CloseError)
+	return ok && t.code == e.code
+}
+
+// ToCloseError returns the CloseError a connection ending with err closes with.
+// nil closes normally, errors wrapping a CloseError keep it and anything else is an internal error
+func ToCloseError(err error) *CloseError {
+	if err == nil {
+		return NewCloseError(CloseNormal, ``)
+	}
+	if ce := findCloseError(err); ce != nil {
+		return ce
+	}
+	return NewCloseError(CloseInternalServerError, err.Error())
+}
+
+// FromGraphQLErrors returns the first CloseError a resolver failed with, or nil if none did
+func FromGraphQLErrors(errs []gqlerrors.FormattedError) *CloseError {
+	for _, fe := range errs {
+		if ce := findCloseError(fe.OriginalError()); ce != nil {
+			return ce
+		}
+	}
+	return nil
+}
+
+// findCloseError unwraps err, including the errors graphql wraps those of resolvers in
+func findCloseError(err error) *CloseError {
+	var ge *gqlerrors.Error
+	if errors.As(err, &ge) && ge.OriginalError != nil {
+		err = ge.OriginalError
+	}
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	return nil
+}
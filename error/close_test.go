@@ -0,0 +1,39 @@
+package gqlwserror_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseError(t *testing.T) {
+	t.Run("matches by code", func(t *testing.T) {
+		err := fmt.Errorf(`init: %w`, gqlwserror.NewCloseError(gqlwserror.CloseUnauthorized, `token expired`))
+		assert.True(t, errors.Is(err, gqlwserror.ErrUnauthorized))
+		assert.False(t, errors.Is(err, gqlwserror.ErrForbidden))
+		var ce *gqlwserror.CloseError
+		assert.True(t, errors.As(err, &ce))
+		assert.Equal(t, `token expired`, ce.Reason())
+	})
+	t.Run("maps to close codes", func(t *testing.T) {
+		assert.Equal(t, gqlwserror.CloseNormal, gqlwserror.ToCloseError(nil).Code())
+		assert.Equal(t, gqlwserror.CloseForbidden, gqlwserror.ToCloseError(fmt.Errorf(`hook: %w`, gqlwserror.ErrForbidden)).Code())
+		ce := gqlwserror.ToCloseError(errors.New(`boom`))
+		assert.Equal(t, gqlwserror.CloseInternalServerError, ce.Code())
+		assert.Equal(t, `boom`, ce.Reason())
+	})
+	t.Run("finds resolver errors", func(t *testing.T) {
+		assert.Nil(t, gqlwserror.FromGraphQLErrors(gqlerrors.FormatErrors(errors.New(`boom`))))
+		located := gqlerrors.NewError(`s`, nil, ``, nil, nil, gqlwserror.ErrForbidden)
+		ce := gqlwserror.FromGraphQLErrors(gqlerrors.FormatErrors(errors.New(`boom`), located))
+		assert.True(t, errors.Is(ce, gqlwserror.ErrForbidden))
+	})
+	t.Run("formats", func(t *testing.T) {
+		assert.Equal(t, `connection closed with 4401: Unauthorized`, gqlwserror.ErrUnauthorized.Error())
+		assert.Equal(t, `connection closed with 1000`, gqlwserror.NewCloseError(gqlwserror.CloseNormal, ``).Error())
+	})
+}
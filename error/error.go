@@ -1,7 +1,6 @@
 package gqlwserror
 
 import (
-	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql/gqlerrors"
 	gqlwsmessage "github.com/onichandame/gql-ws/message"
 )
@@ -25,19 +24,3 @@ func (e *HandlableError) Error() string {
 func (e *HandlableError) GetMessage() *gqlwsmessage.Message {
 	return (&gqlwsmessage.Message{Type: gqlwsmessage.Error, Payload: gqlerrors.FormatErrors(e), ID: &e.ID})
 }
-
-type FatalError struct {
-	code         int
-	gqlwsmessage string
-}
-
-func NewFatalError(code int, msg string) *FatalError {
-	var err FatalError
-	err.code = code
-	err.gqlwsmessage = msg
-	return &err
-}
-
-func (e *FatalError) Error() string {
-	return string(websocket.FormatCloseMessage(e.code, e.gqlwsmessage))
-}
@@ -0,0 +1,109 @@
+package gqlwsmessage
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// the nesting depth a received CBOR item may reach
+const cborMaxDepth = 64
+
+var errCBORMalformed = errors.New(`malformed cbor`)
+
+// checkCBOR rejects an item declaring more bytes than data holds.
+// the decoder allocates the chunks of indefinite-length strings before reading them
+func checkCBOR(data []byte) error {
+	_, err := skipCBOR(data, 0)
+	return err
+}
+
+// skipCBOR returns what follows the first item of data
+func skipCBOR(data []byte, depth int) ([]byte, error) {
+	if depth > cborMaxDepth {
+		return nil, errCBORMalformed
+	}
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	if info == 31 {
+		return skipIndefiniteCBOR(data, major, depth)
+	}
+	n, data, err := cborArgument(data, info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 2, 3:
+		if n > uint64(len(data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return data[n:], nil
+	case 4, 5:
+		if major == 5 {
+			n *= 2
+		}
+		// every item takes at least a byte
+		if n > uint64(len(data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < n; i++ {
+			if data, err = skipCBOR(data, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	case 6:
+		return skipCBOR(data, depth+1)
+	}
+	return data, nil
+}
+
+func skipIndefiniteCBOR(data []byte, major byte, depth int) ([]byte, error) {
+	if major < 2 || major > 5 {
+		return nil, errCBORMalformed
+	}
+	var err error
+	for {
+		if len(data) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if data[0] == 0xff {
+			return data[1:], nil
+		}
+		// strings are made of definite-length chunks of the same type
+		if (major == 2 || major == 3) && (data[0]>>5 != major || data[0]&0x1f == 31) {
+			return nil, errCBORMalformed
+		}
+		if data, err = skipCBOR(data, depth+1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// cborArgument reads the argument following the initial byte of an item
+func cborArgument(data []byte, info byte) (uint64, []byte, error) {
+	size := 0
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, errCBORMalformed
+	}
+	if len(data) < size {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	var buf [8]byte
+	copy(buf[8-size:], data[:size])
+	return binary.BigEndian.Uint64(buf[:]), data[size:], nil
+}
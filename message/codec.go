@@ -0,0 +1,42 @@
+package gqlwsmessage
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Codec encodes the JSON subprotocol. jsoniter, sonic or goccy can be plugged in to replace encoding/json
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// StdCodec is the Codec backed by encoding/json
+type StdCodec struct {
+	// UseNumber decodes numbers into json.Number instead of float64
+	UseNumber bool
+	// DisableHTMLEscape keeps <, > and & unescaped in strings
+	DisableHTMLEscape bool
+}
+
+func (c StdCodec) Marshal(v interface{}) ([]byte, error) {
+	if !c.DisableHTMLEscape {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+func (c StdCodec) Unmarshal(data []byte, v interface{}) error {
+	if !c.UseNumber {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
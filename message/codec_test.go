@@ -0,0 +1,36 @@
+package gqlwsmessage_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdCodec(t *testing.T) {
+	t.Run("escapes html by default", func(t *testing.T) {
+		data, err := gqlwsmessage.StdCodec{}.Marshal(`<a>`)
+		assert.Nil(t, err)
+		assert.Equal(t, `"\u003ca\u003e"`, string(data))
+	})
+	t.Run("can disable html escaping", func(t *testing.T) {
+		data, err := gqlwsmessage.StdCodec{DisableHTMLEscape: true}.Marshal(`<a>`)
+		assert.Nil(t, err)
+		assert.Equal(t, `"<a>"`, string(data))
+	})
+	t.Run("can use number", func(t *testing.T) {
+		var v interface{}
+		assert.Nil(t, gqlwsmessage.StdCodec{UseNumber: true}.Unmarshal([]byte(`12345678901234567890`), &v))
+		assert.Equal(t, json.Number(`12345678901234567890`), v)
+	})
+	t.Run("decodes payload with the codec", func(t *testing.T) {
+		enc := gqlwsmessage.NewJSONEncoding(gqlwsmessage.StdCodec{UseNumber: true})
+		var msg gqlwsmessage.Message
+		assert.Nil(t, enc.Unmarshal([]byte(`{"type":"next","id":"1","payload":{"data":{"n":1}}}`), &msg))
+		var res graphql.Result
+		assert.Nil(t, enc.DecodePayload(msg.Payload, &res))
+		assert.Equal(t, json.Number(`1`), res.Data.(map[string]interface{})[`n`])
+	})
+}
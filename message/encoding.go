@@ -0,0 +1,155 @@
+package gqlwsmessage
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	Subprotocol = `graphql-transport-ws`
+	// SubprotocolMsgpack carries the same messages encoded as MessagePack in binary frames
+	SubprotocolMsgpack = Subprotocol + `+msgpack`
+	// SubprotocolCBOR carries the same messages encoded as CBOR in binary frames
+	SubprotocolCBOR = Subprotocol + `+cbor`
+)
+
+// Encoding serializes messages for the subprotocol it is negotiated by
+type Encoding interface {
+	Subprotocol() string
+	// Binary reports whether messages are sent in binary frames
+	Binary() bool
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal leaves the payload of a Message undecoded until DecodePayload is called on it
+	Unmarshal(data []byte, v interface{}) error
+	// DecodePayload decodes the payload of a received message into v
+	DecodePayload(p Payload, v interface{}) error
+}
+
+var (
+	JSON    Encoding = NewJSONEncoding(StdCodec{})
+	Msgpack Encoding = newMsgpackEncoding()
+	CBOR    Encoding = newCBOREncoding()
+)
+
+// GetEncoding returns the built-in encoding negotiated by subprotocol, or nil if there is none
+func GetEncoding(subprotocol string) Encoding {
+	for _, enc := range []Encoding{JSON, Msgpack, CBOR} {
+		if enc.Subprotocol() == subprotocol {
+			return enc
+		}
+	}
+	return nil
+}
+
+type jsonEncoding struct {
+	codec Codec
+}
+
+// NewJSONEncoding returns the graphql-transport-ws encoding backed by codec
+func NewJSONEncoding(codec Codec) Encoding {
+	return &jsonEncoding{codec: codec}
+}
+
+func (e *jsonEncoding) Subprotocol() string                   { return Subprotocol }
+func (e *jsonEncoding) Binary() bool                          { return false }
+func (e *jsonEncoding) Marshal(v interface{}) ([]byte, error) { return e.codec.Marshal(v) }
+func (e *jsonEncoding) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(*Message)
+	if !ok {
+		return e.codec.Unmarshal(data, v)
+	}
+	var raw struct {
+		Type    Type            `json:"type"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+		ID      *string         `json:"id,omitempty"`
+		Seq     uint64          `json:"seq,omitempty"`
+	}
+	if err := e.codec.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	msg.Type, msg.ID, msg.Seq, msg.Payload = raw.Type, raw.ID, raw.Seq, nil
+	if len(raw.Payload) > 0 && string(raw.Payload) != `null` {
+		msg.Payload = raw.Payload
+	}
+	return nil
+}
+func (e *jsonEncoding) DecodePayload(p Payload, v interface{}) error {
+	if raw, ok := p.(json.RawMessage); ok {
+		return e.codec.Unmarshal(raw, v)
+	}
+	return decodeValue(e, p, v)
+}
+
+// decode objects the same way encoding/json does so payloads can be handled alike
+var mapType = reflect.TypeOf(map[string]interface{}(nil))
+
+type codecEncoding struct {
+	subprotocol string
+	handle      codec.Handle
+	// check rejects malformed frames the decoder cannot be trusted with
+	check func(data []byte) error
+}
+
+func newMsgpackEncoding() *codecEncoding {
+	var h codec.MsgpackHandle
+	h.WriteExt = true
+	h.RawToString = true
+	h.MapType = mapType
+	return &codecEncoding{subprotocol: SubprotocolMsgpack, handle: &h}
+}
+
+func newCBOREncoding() *codecEncoding {
+	var h codec.CborHandle
+	h.MapType = mapType
+	return &codecEncoding{subprotocol: SubprotocolCBOR, handle: &h, check: checkCBOR}
+}
+
+func (e *codecEncoding) Subprotocol() string { return e.subprotocol }
+func (e *codecEncoding) Binary() bool        { return true }
+func (e *codecEncoding) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, e.handle).Encode(v)
+	return data, err
+}
+func (e *codecEncoding) Unmarshal(data []byte, v interface{}) error {
+	if e.check != nil {
+		if err := e.check(data); err != nil {
+			return err
+		}
+	}
+	msg, ok := v.(*Message)
+	if !ok {
+		return codec.NewDecoderBytes(data, e.handle).Decode(v)
+	}
+	var raw struct {
+		Type    Type      `codec:"type"`
+		Payload codec.Raw `codec:"payload,omitempty"`
+		ID      *string   `codec:"id,omitempty"`
+		Seq     uint64    `codec:"seq,omitempty"`
+	}
+	if err := codec.NewDecoderBytes(data, e.handle).Decode(&raw); err != nil {
+		return err
+	}
+	msg.Type, msg.ID, msg.Seq, msg.Payload = raw.Type, raw.ID, raw.Seq, nil
+	if len(raw.Payload) > 0 {
+		msg.Payload = raw.Payload
+	}
+	return nil
+}
+func (e *codecEncoding) DecodePayload(p Payload, v interface{}) error {
+	if raw, ok := p.(codec.Raw); ok {
+		return codec.NewDecoderBytes(raw, e.handle).Decode(v)
+	}
+	return decodeValue(e, p, v)
+}
+
+// decodeValue converts a payload that was built in memory rather than received
+func decodeValue(enc Encoding, p Payload, v interface{}) error {
+	data, err := enc.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return enc.Unmarshal(data, v)
+}
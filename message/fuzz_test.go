@@ -0,0 +1,23 @@
+package gqlwsmessage_test
+
+import (
+	"testing"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// FuzzDecode feeds arbitrary frames to every encoding. decoding must fail with an error rather than panic
+func FuzzDecode(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, enc := range []gqlwsmessage.Encoding{gqlwsmessage.JSON, gqlwsmessage.Msgpack, gqlwsmessage.CBOR} {
+			var msg gqlwsmessage.Message
+			if enc.Unmarshal(data, &msg) != nil || msg.Payload == nil {
+				continue
+			}
+			var payload gqlwsmessage.Payload
+			enc.DecodePayload(msg.Payload, &payload)
+			var query gqlwsmessage.SubscribePayload
+			enc.DecodePayload(msg.Payload, &query)
+		}
+	})
+}
@@ -17,8 +17,22 @@ type Message struct {
 	Type    Type    `json:"type"`
 	Payload Payload `json:"payload,omitempty"`
 	ID      *string `json:"id,omitempty"`
+	// Seq numbers the operation messages of a resumable session
+	Seq uint64 `json:"seq,omitempty"`
 }
 
+// keys of the payloads resuming a session and refreshing its credentials
+const (
+	// SessionTokenKey carries the token issued in connection_ack and presented back in connection_init
+	SessionTokenKey = `sessionToken`
+	// LastSeqKey carries the sequence of the last message the client received
+	LastSeqKey = `lastSeq`
+	// ResumedKey reports in connection_ack whether the session was resumed
+	ResumedKey = `resumed`
+	// RefreshKey carries the credentials of a ping payload refreshing those of connection_init
+	RefreshKey = `refresh`
+)
+
 type Payload interface{}
 
 type SubscribePayload struct {
@@ -0,0 +1,170 @@
+package gqlwsmessage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keys of the payloads negotiating and carrying JSON patches
+const (
+	// JSONPatchKey requests in connection_init, and confirms in connection_ack, that the results following
+	// the first of an operation are sent as JSON patches against the previous one
+	JSONPatchKey = `jsonPatch`
+	// PatchKey carries the patch of a next payload
+	PatchKey = `patch`
+)
+
+// PatchOperation is an operation of an RFC 6902 JSON patch.
+// value is always encoded so that add and replace can set null. remove ignores it
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Diff returns the patch turning from into to. both are JSON documents in their generic form
+func Diff(from, to interface{}) []PatchOperation {
+	return diff(``, from, to, []PatchOperation{})
+}
+
+func diff(path string, from, to interface{}, patch []PatchOperation) []PatchOperation {
+	fromObj, fromOk := from.(map[string]interface{})
+	toObj, toOk := to.(map[string]interface{})
+	if fromOk && toOk {
+		for _, k := range sortedKeys(fromObj) {
+			if _, ok := toObj[k]; !ok {
+				patch = append(patch, PatchOperation{Op: `remove`, Path: path + `/` + escapePointer(k)})
+			}
+		}
+		for _, k := range sortedKeys(toObj) {
+			if v, ok := fromObj[k]; ok {
+				patch = diff(path+`/`+escapePointer(k), v, toObj[k], patch)
+			} else {
+				patch = append(patch, PatchOperation{Op: `add`, Path: path + `/` + escapePointer(k), Value: toObj[k]})
+			}
+		}
+		return patch
+	}
+	fromArr, fromOk := from.([]interface{})
+	toArr, toOk := to.([]interface{})
+	if fromOk && toOk && len(fromArr) == len(toArr) {
+		for i := range toArr {
+			patch = diff(path+`/`+strconv.Itoa(i), fromArr[i], toArr[i], patch)
+		}
+		return patch
+	}
+	if reflect.DeepEqual(from, to) {
+		return patch
+	}
+	return append(patch, PatchOperation{Op: `replace`, Path: path, Value: to})
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, `~`, `~0`), `/`, `~1`)
+}
+
+func unescapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, `~1`, `/`), `~0`, `~`)
+}
+
+// ApplyPatch applies the add, remove and replace operations of patch to doc, which may be modified in place.
+// returns the patched document
+func ApplyPatch(doc interface{}, patch []PatchOperation) (interface{}, error) {
+	for _, op := range patch {
+		var tokens []string
+		if op.Path != `` {
+			if !strings.HasPrefix(op.Path, `/`) {
+				return nil, fmt.Errorf(`invalid patch path %v`, op.Path)
+			}
+			for _, token := range strings.Split(op.Path[1:], `/`) {
+				tokens = append(tokens, unescapePointer(token))
+			}
+		}
+		var err error
+		if doc, err = applyOperation(doc, tokens, &op); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func applyOperation(doc interface{}, tokens []string, op *PatchOperation) (interface{}, error) {
+	if len(tokens) == 0 {
+		switch op.Op {
+		case `add`, `replace`:
+			return op.Value, nil
+		case `remove`:
+			return nil, nil
+		}
+		return nil, fmt.Errorf(`patch operation %v not supported`, op.Op)
+	}
+	token, last := tokens[0], len(tokens) == 1
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[token]
+		if !last {
+			if !ok {
+				return nil, fmt.Errorf(`patch path %v not found`, op.Path)
+			}
+			v, err := applyOperation(child, tokens[1:], op)
+			d[token] = v
+			return d, err
+		}
+		switch op.Op {
+		case `add`:
+			d[token] = op.Value
+		case `replace`, `remove`:
+			if !ok {
+				return nil, fmt.Errorf(`patch path %v not found`, op.Path)
+			}
+			if op.Op == `replace` {
+				d[token] = op.Value
+			} else {
+				delete(d, token)
+			}
+		default:
+			return nil, fmt.Errorf(`patch operation %v not supported`, op.Op)
+		}
+		return d, nil
+	case []interface{}:
+		if last && op.Op == `add` && token == `-` {
+			return append(d, op.Value), nil
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > len(d) || (i == len(d) && !(last && op.Op == `add`)) {
+			return nil, fmt.Errorf(`patch path %v not found`, op.Path)
+		}
+		if !last {
+			v, err := applyOperation(d[i], tokens[1:], op)
+			d[i] = v
+			return d, err
+		}
+		switch op.Op {
+		case `add`:
+			d = append(d, nil)
+			copy(d[i+1:], d[i:])
+			d[i] = op.Value
+		case `replace`:
+			d[i] = op.Value
+		case `remove`:
+			d = append(d[:i], d[i+1:]...)
+		default:
+			return nil, fmt.Errorf(`patch operation %v not supported`, op.Op)
+		}
+		return d, nil
+	}
+	return nil, errors.New(`patch path ` + op.Path + ` not found`)
+}
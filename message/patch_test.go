@@ -0,0 +1,79 @@
+package gqlwsmessage_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatch(t *testing.T) {
+	decode := func(t *testing.T, s string) interface{} {
+		var v interface{}
+		assert.Nil(t, json.Unmarshal([]byte(s), &v))
+		return v
+	}
+	t.Run("diffs changed fields", func(t *testing.T) {
+		from := decode(t, `{"data":{"user":{"name":"alice","a/b":1,"tags":["x","y"],"old":true}}}`)
+		to := decode(t, `{"data":{"user":{"name":"bob","a/b":1,"tags":["x","z"],"new":null}}}`)
+		assert.Equal(t, []gqlwsmessage.PatchOperation{
+			{Op: `remove`, Path: `/data/user/old`},
+			{Op: `replace`, Path: `/data/user/name`, Value: `bob`},
+			{Op: `add`, Path: `/data/user/new`},
+			{Op: `replace`, Path: `/data/user/tags/1`, Value: `z`},
+		}, gqlwsmessage.Diff(from, to))
+	})
+	t.Run("replaces resized arrays", func(t *testing.T) {
+		assert.Equal(t, []gqlwsmessage.PatchOperation{{Op: `replace`, Path: `/a`, Value: []interface{}{1.0}}},
+			gqlwsmessage.Diff(decode(t, `{"a":[]}`), decode(t, `{"a":[1]}`)))
+	})
+	t.Run("applies its diffs", func(t *testing.T) {
+		for _, c := range [][2]string{
+			{`{"data":{"n":1,"l":[1,2]}}`, `{"data":{"n":2,"l":[1,3]},"errors":[{"message":"m"}]}`},
+			{`{"a~b":{"c/d":1}}`, `{"a~b":{"c/d":2}}`},
+			{`{"a":1}`, `[1]`},
+			{`{"a":1}`, `{"a":1}`},
+		} {
+			from, to := decode(t, c[0]), decode(t, c[1])
+			patched, err := gqlwsmessage.ApplyPatch(from, gqlwsmessage.Diff(from, to))
+			assert.Nil(t, err)
+			assert.Equal(t, decode(t, c[1]), patched)
+		}
+	})
+	t.Run("applies array operations", func(t *testing.T) {
+		patched, err := gqlwsmessage.ApplyPatch(decode(t, `[1,2,3]`), []gqlwsmessage.PatchOperation{
+			{Op: `remove`, Path: `/0`},
+			{Op: `add`, Path: `/1`, Value: 4.0},
+			{Op: `add`, Path: `/-`, Value: 5.0},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, decode(t, `[2,4,3,5]`), patched)
+	})
+	t.Run("encodes null values", func(t *testing.T) {
+		for _, from := range []string{`{"a":1}`, `{}`} {
+			from, to := decode(t, from), decode(t, `{"a":null}`)
+			data, err := json.Marshal(gqlwsmessage.Diff(from, to))
+			assert.Nil(t, err)
+			// RFC 6902 requires the value of add and replace
+			assert.Contains(t, string(data), `"value":null`)
+			var patch []gqlwsmessage.PatchOperation
+			assert.Nil(t, json.Unmarshal(data, &patch))
+			patched, err := gqlwsmessage.ApplyPatch(from, patch)
+			assert.Nil(t, err)
+			assert.Equal(t, to, patched, string(data))
+		}
+	})
+	t.Run("rejects invalid patches", func(t *testing.T) {
+		for _, op := range []gqlwsmessage.PatchOperation{
+			{Op: `replace`, Path: `/missing`},
+			{Op: `add`, Path: `/a/b/c`},
+			{Op: `add`, Path: `/l/3`},
+			{Op: `move`, Path: `/a`},
+			{Op: `add`, Path: `a`},
+		} {
+			_, err := gqlwsmessage.ApplyPatch(decode(t, `{"a":1,"l":[1]}`), []gqlwsmessage.PatchOperation{op})
+			assert.NotNil(t, err, op)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package gqlwsserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AdminCSRFHeader marks a POST to an AdminHandler as sent by a script rather than a cross-site form, which cannot set it.
+// the forms of the page carry a token instead
+const AdminCSRFHeader = `X-Requested-With`
+
+type AdminConfig struct {
+	// Registry holds the sockets the handler lists. must be the Registry of the served Config
+	Registry *Registry
+	// Authorize guards every request. all of them are forbidden without it
+	Authorize func(*http.Request) bool
+	// RedactParams returns the connection params shown in place of params. by default only the keys of an object are shown
+	RedactParams func(params ConnectionParams) interface{}
+}
+
+func (c *AdminConfig) init() {
+	if c.Registry == nil {
+		panic(errors.New(`gql-ws admin received invalid parameters`))
+	}
+	if c.Authorize == nil {
+		c.Authorize = func(r *http.Request) bool { return false }
+	}
+	if c.RedactParams == nil {
+		c.RedactParams = redactParams
+	}
+}
+
+// AdminHandler lists the connections of a Registry and their operations, and lets them be ended.
+// mount it on a path ending with a slash, stripping the prefix:
+//
+//	GET  /                                         lists the connections in JSON, or in HTML for browsers
+//	POST /connections/{id}/close                   closes a connection
+//	POST /connections/{id}/operations/{op}/complete completes an operation
+//
+// a POST must carry AdminCSRFHeader or the token embedded in the forms of the page
+type AdminHandler struct {
+	*AdminConfig
+
+	// the token of the forms of the page
+	csrfToken string
+}
+
+func NewAdminHandler(cfg *AdminConfig) *AdminHandler {
+	var h AdminHandler
+	cfg.init()
+	h.AdminConfig = cfg
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	h.csrfToken = hex.EncodeToString(token)
+	return &h
+}
+
+// adminConnection is a connection as listed by an AdminHandler
+type adminConnection struct {
+	ID          string        `json:"id"`
+	RemoteAddr  string        `json:"remoteAddr,omitempty"`
+	ConnectedAt time.Time     `json:"connectedAt"`
+	Params      interface{}   `json:"params,omitempty"`
+	Operations  []Operation   `json:"operations"`
+	Received    uint64        `json:"received"`
+	Sent        uint64        `json:"sent"`
+	Dropped     uint64        `json:"dropped"`
+	RTT         time.Duration `json:"rtt"`
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Authorize(r) {
+		http.Error(w, `Forbidden`, http.StatusForbidden)
+		return
+	}
+	// ids may hold escaped slashes
+	path := strings.Split(strings.Trim(r.URL.EscapedPath(), `/`), `/`)
+	for i, segment := range path {
+		var err error
+		if path[i], err = url.PathUnescape(segment); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if len(path) == 1 && path[0] == `` {
+		if r.Method != http.MethodGet {
+			w.Header().Set(`Allow`, `GET`)
+			http.Error(w, `Method not allowed`, http.StatusMethodNotAllowed)
+			return
+		}
+		h.list(w, r)
+		return
+	}
+	if len(path) < 3 || path[0] != `connections` {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set(`Allow`, `POST`)
+		http.Error(w, `Method not allowed`, http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get(AdminCSRFHeader) == `` && subtle.ConstantTimeCompare([]byte(r.PostFormValue(`csrf`)), []byte(h.csrfToken)) != 1 {
+		http.Error(w, `Missing CSRF token`, http.StatusForbidden)
+		return
+	}
+	sock := h.Registry.Get(path[1])
+	if sock == nil {
+		http.Error(w, `Connection not found`, http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(path) == 3 && path[2] == `close`:
+		sock.Close()
+	case len(path) == 5 && path[2] == `operations` && path[4] == `complete`:
+		if err := sock.CompleteOperation(path[3]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	// forms posted from the page return to it. the location is relative as the handler does not know its mount path
+	if acceptsHTML(r) {
+		w.Header().Set(`Location`, strings.Repeat(`../`, len(path)-1))
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) list(w http.ResponseWriter, r *http.Request) {
+	conns := []adminConnection{}
+	for _, sock := range h.Registry.Sockets() {
+		conns = append(conns, adminConnection{
+			ID:          sock.ID(),
+			RemoteAddr:  sock.RemoteAddr(),
+			ConnectedAt: sock.ConnectedAt(),
+			Params:      h.RedactParams(sock.ConnectionParams()),
+			Operations:  sock.Operations(),
+			Received:    sock.Received(),
+			Sent:        sock.Sent(),
+			Dropped:     sock.Dropped(),
+			RTT:         sock.RTT(),
+		})
+	}
+	if acceptsHTML(r) {
+		w.Header().Set(`Content-Type`, `text/html; charset=utf-8`)
+		adminPage.Execute(w, map[string]interface{}{"Connections": conns, "CSRFToken": h.csrfToken})
+		return
+	}
+	w.Header().Set(`Content-Type`, mediaTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{"connections": conns})
+}
+
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get(`Accept`), `text/html`)
+}
+
+// redactParams keeps the keys of object params and hides everything else
+func redactParams(params ConnectionParams) interface{} {
+	if params == nil {
+		return nil
+	}
+	obj, ok := params.(map[string]interface{})
+	if !ok {
+		return `[redacted]`
+	}
+	redacted := make(map[string]interface{}, len(obj))
+	for k := range obj {
+		redacted[k] = `[redacted]`
+	}
+	return redacted
+}
+
+var adminPage = template.Must(template.New(`admin`).Funcs(template.FuncMap{"pathEscape": url.PathEscape}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>gql-ws connections</title></head>
+<body>
+<h1>Connections ({{len .Connections}})</h1>
+{{$token := .CSRFToken}}{{range .Connections}}
+<section>
+<h2>{{.ID}}</h2>
+<form method="post" action="connections/{{pathEscape .ID}}/close"><input type="hidden" name="csrf" value="{{$token}}"><button>Close</button></form>
+<p>from {{.RemoteAddr}} since {{.ConnectedAt.Format "2006-01-02 15:04:05"}}, received {{.Received}}, sent {{.Sent}}, dropped {{.Dropped}}, rtt {{.RTT}}</p>
+<p>params: {{printf "%v" .Params}}</p>
+<table>
+<tr><th>id</th><th>name</th><th>type</th><th>query hash</th><th>variables</th><th>started</th><th>sent</th><th></th></tr>
+{{$conn := .ID}}{{range .Operations}}
+<tr><td>{{.ID}}</td><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.QueryHash}}</td><td>{{printf "%v" .Variables}}</td><td>{{.StartedAt.Format "15:04:05"}}</td><td>{{.Sent}}</td>
+<td><form method="post" action="connections/{{pathEscape $conn}}/operations/{{pathEscape .ID}}/complete"><input type="hidden" name="csrf" value="{{$token}}"><button>Complete</button></form></td></tr>
+{{end}}
+</table>
+</section>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,138 @@
+package gqlwsserver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler(t *testing.T) {
+	registry := gqlwsserver.NewRegistry()
+	handler := gqlwsserver.NewAdminHandler(&gqlwsserver.AdminConfig{
+		Registry:  registry,
+		Authorize: func(r *http.Request) bool { return r.Header.Get(`Authorization`) == `admin` },
+	})
+	do := func(method, path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set(`Authorization`, `admin`)
+		r.Header.Set(`Accept`, accept)
+		r.Header.Set(gqlwsserver.AdminCSRFHeader, `test`)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), Registry: registry})
+	id := `an/operation`
+	conn.SubscribeWithID(id, gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+	conn.ExpectNext(id)
+	t.Run("requires authorization", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, `/`, nil))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("lists connections", func(t *testing.T) {
+		w := do(http.MethodGet, `/`, `application/json`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Connections []struct {
+				ID         string
+				Params     interface{}
+				Received   uint64
+				Sent       uint64
+				Operations []gqlwsserver.Operation
+			}
+		}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+		if assert.Len(t, body.Connections, 1) {
+			c := body.Connections[0]
+			assert.Equal(t, sock.ID(), c.ID)
+			assert.Nil(t, c.Params)
+			assert.GreaterOrEqual(t, c.Received, uint64(2))
+			assert.GreaterOrEqual(t, c.Sent, uint64(2))
+			if assert.Len(t, c.Operations, 1) {
+				assert.Equal(t, id, c.Operations[0].ID)
+			}
+		}
+	})
+	t.Run("renders a page", func(t *testing.T) {
+		w := do(http.MethodGet, `/`, `text/html`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `connections/`+sock.ID()+`/operations/an%2Foperation/complete`)
+	})
+	t.Run("requires CSRF protection", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, `/connections/`+sock.ID()+`/close`, nil)
+		r.Header.Set(`Authorization`, `admin`)
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("accepts the token of the forms", func(t *testing.T) {
+		token := regexp.MustCompile(`name="csrf" value="([^"]+)"`).FindStringSubmatch(do(http.MethodGet, `/`, `text/html`).Body.String())
+		if !assert.Len(t, token, 2) {
+			return
+		}
+		post := func(csrf string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, `/connections/`+sock.ID()+`/operations/unknown/complete`, strings.NewReader(url.Values{"csrf": {csrf}}.Encode()))
+			r.Header.Set(`Authorization`, `admin`)
+			r.Header.Set(`Accept`, `text/html`)
+			r.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+			r.Header.Set(`Referer`, `https://evil.example`)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w
+		}
+		assert.Equal(t, http.StatusForbidden, post(`forged`).Code)
+		// the unknown operation is reported rather than redirected
+		assert.Equal(t, http.StatusNotFound, post(token[1]).Code)
+	})
+	t.Run("completes an operation", func(t *testing.T) {
+		w := do(http.MethodPost, `/connections/`+sock.ID()+`/operations/an%2Foperation/complete`, ``)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		for {
+			msg, err := conn.Receive()
+			if assert.Nil(t, err) && msg.Type == gqlwsmessage.Next {
+				continue
+			}
+			assert.Equal(t, gqlwsmessage.Complete, msg.Type)
+			break
+		}
+		w = do(http.MethodPost, `/connections/`+sock.ID()+`/operations/an%2Foperation/complete`, ``)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("closes a connection", func(t *testing.T) {
+		w := do(http.MethodPost, `/connections/`+sock.ID()+`/close`, `text/html`)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, `../../`, w.Header().Get(`Location`))
+		sock.Wait()
+		w = do(http.MethodGet, `/`, `application/json`)
+		assert.False(t, strings.Contains(w.Body.String(), sock.ID()))
+		w = do(http.MethodPost, `/connections/`+sock.ID()+`/close`, ``)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestRedactParams(t *testing.T) {
+	registry := gqlwsserver.NewRegistry()
+	handler := gqlwsserver.NewAdminHandler(&gqlwsserver.AdminConfig{
+		Registry:  registry,
+		Authorize: func(r *http.Request) bool { return true },
+	})
+	conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), Registry: registry})
+	defer conn.Close()
+	conn.Init(map[string]interface{}{"token": `secret`})
+	assert.Eventually(t, func() bool { return len(registry.Sockets()) == 1 }, time.Second, time.Millisecond)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, `/`, nil))
+	assert.NotContains(t, w.Body.String(), `secret`)
+	assert.Contains(t, w.Body.String(), `"token":"[redacted]"`)
+}
@@ -0,0 +1,143 @@
+package gqlwsserver
+
+import (
+	"sync"
+	"time"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// BackpressurePolicy decides what happens to results produced while an operation's buffer is full
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock stalls the operation until the client catches up
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropOldest discards the oldest queued result to make room
+	BackpressureDropOldest
+	// BackpressureDropNewest discards the incoming result
+	BackpressureDropNewest
+	// BackpressureConflate replaces the newest queued result with the incoming one
+	BackpressureConflate
+	// BackpressureClose blocks like BackpressureBlock but closes the socket once SlowConsumerTimeout elapses
+	BackpressureClose
+)
+
+// outbox is the bounded queue between an operation and the socket writer
+type outbox struct {
+	id      string
+	size    int
+	policy  BackpressurePolicy
+	timeout time.Duration
+	onDrop  func(string, *gqlwsmessage.Message)
+	// closed when results can no longer be delivered
+	done <-chan interface{}
+
+	lock   sync.Mutex
+	items  []*gqlwsmessage.Message
+	closed bool
+	ready  chan struct{}
+	space  chan struct{}
+}
+
+func newOutbox(id string, cfg *Config, done <-chan interface{}, onDrop func(string, *gqlwsmessage.Message)) *outbox {
+	var ob outbox
+	ob.id = id
+	ob.size = cfg.OperationBufferSize
+	ob.policy = cfg.Backpressure
+	ob.timeout = cfg.SlowConsumerTimeout
+	ob.onDrop = onDrop
+	ob.done = done
+	ob.ready = make(chan struct{}, 1)
+	ob.space = make(chan struct{}, 1)
+	return &ob
+}
+
+// push queues msg according to the policy. returns a fatal error if the consumer is too slow
+func (ob *outbox) push(msg *gqlwsmessage.Message) error {
+	var timeout <-chan time.Time
+	if ob.policy == BackpressureClose {
+		timer := time.NewTimer(ob.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		ob.lock.Lock()
+		if ob.closed {
+			ob.lock.Unlock()
+			return nil
+		}
+		if len(ob.items) < ob.size {
+			ob.items = append(ob.items, msg)
+			ob.lock.Unlock()
+			signal(ob.ready)
+			return nil
+		}
+		switch ob.policy {
+		case BackpressureDropOldest:
+			dropped := ob.items[0]
+			ob.items = append(ob.items[1:], msg)
+			ob.lock.Unlock()
+			ob.onDrop(ob.id, dropped)
+			return nil
+		case BackpressureDropNewest:
+			ob.lock.Unlock()
+			ob.onDrop(ob.id, msg)
+			return nil
+		case BackpressureConflate:
+			dropped := ob.items[len(ob.items)-1]
+			ob.items[len(ob.items)-1] = msg
+			ob.lock.Unlock()
+			ob.onDrop(ob.id, dropped)
+			return nil
+		}
+		ob.lock.Unlock()
+		select {
+		case <-ob.space:
+		case <-ob.done:
+			return nil
+		case <-timeout:
+			return gqlwserror.NewCloseError(gqlwserror.CloseSlowConsumer, `Slow consumer`)
+		}
+	}
+}
+
+// pop blocks until a message is queued. returns false once the outbox is closed and drained
+func (ob *outbox) pop() (*gqlwsmessage.Message, bool) {
+	for {
+		ob.lock.Lock()
+		if len(ob.items) > 0 {
+			msg := ob.items[0]
+			ob.items = ob.items[1:]
+			ob.lock.Unlock()
+			signal(ob.space)
+			return msg, true
+		}
+		if ob.closed {
+			ob.lock.Unlock()
+			return nil, false
+		}
+		ob.lock.Unlock()
+		select {
+		case <-ob.ready:
+		case <-ob.done:
+			return nil, false
+		}
+	}
+}
+
+// close stops accepting messages. queued messages can still be popped
+func (ob *outbox) close() {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	ob.closed = true
+	signal(ob.ready)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,82 @@
+package gqlwsserver
+
+import (
+	"testing"
+	"time"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	msgs := func(n int) []*gqlwsmessage.Message {
+		res := make([]*gqlwsmessage.Message, n)
+		for i := range res {
+			res[i] = &gqlwsmessage.Message{Type: gqlwsmessage.Next, Payload: i}
+		}
+		return res
+	}
+	fill := func(policy BackpressurePolicy) (*outbox, []*gqlwsmessage.Message, []*gqlwsmessage.Message) {
+		var dropped []*gqlwsmessage.Message
+		cfg := Config{OperationBufferSize: 2, Backpressure: policy, SlowConsumerTimeout: time.Millisecond * 10}
+		ob := newOutbox(`id`, &cfg, make(chan interface{}), func(id string, m *gqlwsmessage.Message) { dropped = append(dropped, m) })
+		in := msgs(3)
+		for _, m := range in {
+			assert.Nil(t, ob.push(m))
+		}
+		return ob, in, dropped
+	}
+	drain := func(ob *outbox) []*gqlwsmessage.Message {
+		ob.close()
+		var res []*gqlwsmessage.Message
+		for {
+			m, ok := ob.pop()
+			if !ok {
+				return res
+			}
+			res = append(res, m)
+		}
+	}
+	t.Run("drop oldest", func(t *testing.T) {
+		ob, in, dropped := fill(BackpressureDropOldest)
+		assert.Equal(t, in[:1], dropped)
+		assert.Equal(t, in[1:], drain(ob))
+	})
+	t.Run("drop newest", func(t *testing.T) {
+		ob, in, dropped := fill(BackpressureDropNewest)
+		assert.Equal(t, in[2:], dropped)
+		assert.Equal(t, in[:2], drain(ob))
+	})
+	t.Run("conflate", func(t *testing.T) {
+		ob, in, dropped := fill(BackpressureConflate)
+		assert.Equal(t, in[1:2], dropped)
+		assert.Equal(t, []*gqlwsmessage.Message{in[0], in[2]}, drain(ob))
+	})
+	t.Run("block", func(t *testing.T) {
+		cfg := Config{OperationBufferSize: 1, Backpressure: BackpressureBlock}
+		ob := newOutbox(`id`, &cfg, make(chan interface{}), nil)
+		in := msgs(2)
+		assert.Nil(t, ob.push(in[0]))
+		pushed := make(chan error)
+		go func() { pushed <- ob.push(in[1]) }()
+		select {
+		case <-pushed:
+			t.Fatal(`push should block on a full buffer`)
+		case <-time.After(time.Millisecond * 10):
+		}
+		m, ok := ob.pop()
+		assert.True(t, ok)
+		assert.Equal(t, in[0], m)
+		assert.Nil(t, <-pushed)
+		assert.Equal(t, in[1:], drain(ob))
+	})
+	t.Run("close", func(t *testing.T) {
+		cfg := Config{OperationBufferSize: 1, Backpressure: BackpressureClose, SlowConsumerTimeout: time.Millisecond * 10}
+		ob := newOutbox(`id`, &cfg, make(chan interface{}), nil)
+		in := msgs(2)
+		assert.Nil(t, ob.push(in[0]))
+		err := ob.push(in[1])
+		assert.IsType(t, new(gqlwserror.CloseError), err)
+	})
+}
@@ -0,0 +1,83 @@
+package gqlwsserver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const batchBufferSize = 32 << 10
+
+// batchConn buffers the frames written to the hijacked connection so that
+// several messages can share a single flush
+type batchConn struct {
+	net.Conn
+
+	lock sync.Mutex
+	buf  *bufio.Writer
+	held bool
+	// set once the connection is closing so the close frame is not held back
+	disabled bool
+}
+
+func newBatchConn(conn net.Conn) *batchConn {
+	var bc batchConn
+	bc.Conn = conn
+	bc.buf = bufio.NewWriterSize(conn, batchBufferSize)
+	return &bc
+}
+
+// Write flushes immediately unless a batch is being held
+func (bc *batchConn) Write(p []byte) (int, error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	n, err := bc.buf.Write(p)
+	if err == nil && !bc.held {
+		err = bc.buf.Flush()
+	}
+	return n, err
+}
+
+// hold defers flushing until the next call to flush
+func (bc *batchConn) hold() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.held = !bc.disabled
+}
+
+func (bc *batchConn) flush() error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.held = false
+	return bc.buf.Flush()
+}
+
+// disable flushes what is buffered and stops holding further writes
+func (bc *batchConn) disable() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.disabled = true
+	bc.held = false
+	bc.buf.Flush()
+}
+
+// batchResponseWriter hands a batchConn to the upgrader when it hijacks the connection
+type batchResponseWriter struct {
+	http.ResponseWriter
+	conn *batchConn
+}
+
+func (w *batchResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New(`response writer does not support hijacking`)
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = newBatchConn(conn)
+	return w.conn, brw, nil
+}
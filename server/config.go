@@ -8,17 +8,87 @@ import (
 
 	"github.com/graphql-go/graphql"
 	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
 )
 
 type Config struct {
+	// Response and Request are the upgraded request of a Socket. the HTTP transports leave them empty
 	Response http.ResponseWriter
 	Request  *http.Request
-	Schema   *graphql.Schema
+	// Transport replaces the websocket upgraded from Response and Request, e.g. an end of gqlwstransport.Pipe
+	Transport gqlwstransport.Transport
+	Schema    *graphql.Schema
 
 	GraceClosePeriod, ConnectionInitTimeout time.Duration
+	// IdleTimeout closes a socket that had no operations running for as long. 0 keeps idle sockets open
+	IdleTimeout time.Duration
+	// WriteBatchSize caps the number of messages coalesced into a single flush. 1 disables coalescing
+	WriteBatchSize int
+	// WriteFlushLatency is how long a pending message may wait for others to join its batch.
+	// by default only the messages already queued are coalesced
+	WriteFlushLatency time.Duration
+	// Codec encodes the JSON subprotocol. defaults to encoding/json
+	Codec gqlwsmessage.Codec
+	// Encodings are offered to clients besides JSON, in order of preference
+	Encodings []gqlwsmessage.Encoding
+	// EnableCompression negotiates permessage-deflate with clients that offer it
+	EnableCompression bool
+	// CompressionLevel is the flate level of compressed messages. 0 keeps the websocket default
+	CompressionLevel int
+	// CompressionThreshold is the minimum encoded size in bytes of a message to be compressed
+	CompressionThreshold int
+	// OperationBufferSize bounds the results queued per operation before Backpressure applies
+	OperationBufferSize int
+	Backpressure        BackpressurePolicy
+	// SlowConsumerTimeout is how long BackpressureClose waits on a full buffer before closing the socket
+	SlowConsumerTimeout time.Duration
+	// OnDrop is called with every result discarded by the backpressure policy
+	OnDrop func(id string, msg *gqlwsmessage.Message)
+	// EnableJSONPatch sends the results following the first of an operation as JSON patches against the previous one
+	// to the clients asking for it with gqlwsmessage.JSONPatchKey in connection_init
+	EnableJSONPatch bool
+	// DisableIntrospection rejects queries of __schema and __type during validation
+	DisableIntrospection bool
+	// AllowIntrospection decides per connection whether its params allow introspection, e.g. for internal tools.
+	// takes precedence over DisableIntrospection
+	AllowIntrospection func(ConnectionParams) bool
+	// OperationTimeout cancels the context of a query or mutation running longer and answers it with an error
+	OperationTimeout time.Duration
+	// SubscriptionLifetime cancels the context of a subscription running longer and completes it
+	SubscriptionLifetime time.Duration
+	// OperationTimeoutFunc returns the timeout of an operation of the given type, overriding the two above. 0 means none
+	OperationTimeoutFunc func(params ConnectionParams, q *gqlwsmessage.SubscribePayload, operation string) time.Duration
+	// TrustedDocuments restricts the operations to the documents it holds, which clients refer to by id
+	TrustedDocuments DocumentStore
+	// RedactVariables returns the variables reported by Socket.Operations in place of vars, e.g. without secrets.
+	// must not modify vars. by default only their names are reported
+	RedactVariables func(vars map[string]interface{}) map[string]interface{}
+	// Sessions lets clients resume their operations after a reconnect. shared by all the sockets of a server
+	Sessions *Sessions
+	// Registry lists the initialised sockets, e.g. for an AdminHandler. shared by all the sockets of a server
+	Registry *Registry
+	// LiveQueries keeps the queries marked with @live open. shared by all the sockets of a server.
+	// a live query is timed as a subscription
+	LiveQueries *LiveQueries
 
+	// the hooks may panic with a *gqlwserror.CloseError to close the socket with its code. any other panic closes it with 4500
 	OnConnectionInit, OnPing func(*gqlwsmessage.Message) gqlwsmessage.Payload
 	OnPong                   func(*gqlwsmessage.Message)
+	// OnExpiry is called once the credentials set by ExpiresAt expire, e.g. to re-authenticate the connection.
+	// returns their new expiry, or an error to close the socket with 4401
+	OnExpiry func(ConnectionParams) (time.Time, error)
+	// OnRefresh validates the credentials a client sends under gqlwsmessage.RefreshKey of a ping payload.
+	// returns their new expiry, or an error to close the socket with 4401. refreshes are ignored without it.
+	// accepted credentials update the connection params: the entries of a map replace those of the same keys,
+	// any other value is stored under gqlwsmessage.RefreshKey
+	OnRefresh func(*gqlwsmessage.Message) (time.Time, error)
+	// MaxRequestBodySize caps the bytes read from the body of an HTTP request. defaults to 1 MiB
+	MaxRequestBodySize int64
+	// SSEReservationTimeout discards a reservation of single connection mode whose stream is not opened in time
+	SSEReservationTimeout time.Duration
+	// OnRequest takes the place of connection_init for the HTTP transports.
+	// returns the connection params of the request, or an error to reject it as unauthorized
+	OnRequest func(*http.Request) (ConnectionParams, error)
 	// Context is passed to resolvers. can be used to pass context-related values
 	Context context.Context
 }
@@ -26,6 +96,11 @@ type Config struct {
 var defaultConfig = Config{
 	GraceClosePeriod:      time.Second * 5,
 	ConnectionInitTimeout: time.Second * 30,
+	WriteBatchSize:        32,
+	OperationBufferSize:   16,
+	SlowConsumerTimeout:   time.Second * 5,
+	MaxRequestBodySize:    1 << 20,
+	SSEReservationTimeout: time.Second * 30,
 	Context:               context.Background(),
 }
 
@@ -36,13 +111,44 @@ func (c *Config) init() {
 	if c.ConnectionInitTimeout <= 0 {
 		c.ConnectionInitTimeout = time.Second * 30
 	}
+	if c.WriteBatchSize <= 0 {
+		c.WriteBatchSize = defaultConfig.WriteBatchSize
+	}
+	if c.WriteFlushLatency < 0 {
+		c.WriteFlushLatency = 0
+	}
+	if c.Codec == nil {
+		c.Codec = gqlwsmessage.StdCodec{}
+	}
+	if c.CompressionThreshold < 0 {
+		c.CompressionThreshold = 0
+	}
+	if c.OperationBufferSize <= 0 {
+		c.OperationBufferSize = defaultConfig.OperationBufferSize
+	}
+	if c.SlowConsumerTimeout <= 0 {
+		c.SlowConsumerTimeout = defaultConfig.SlowConsumerTimeout
+	}
+	if c.MaxRequestBodySize <= 0 {
+		c.MaxRequestBodySize = defaultConfig.MaxRequestBodySize
+	}
+	if c.SSEReservationTimeout <= 0 {
+		c.SSEReservationTimeout = defaultConfig.SSEReservationTimeout
+	}
+	if c.OnDrop == nil {
+		c.OnDrop = func(id string, msg *gqlwsmessage.Message) {}
+	}
 	if c.Context == nil {
 		c.Context = defaultConfig.Context
 	}
-	if c.Response == nil ||
-		c.Request == nil ||
-		c.Schema == nil {
-		panic(errors.New(`gql-ws socket received invalid parameters`))
+	if c.RedactVariables == nil {
+		c.RedactVariables = redactVariables
+	}
+	if c.OperationTimeoutFunc == nil {
+		c.OperationTimeoutFunc = c.defaultOperationTimeout
+	}
+	if c.Schema == nil {
+		panic(errors.New(`gql-ws received invalid parameters`))
 	}
 	if c.OnConnectionInit == nil {
 		c.OnConnectionInit = func(m *gqlwsmessage.Message) gqlwsmessage.Payload { return nil }
@@ -53,4 +159,7 @@ func (c *Config) init() {
 	if c.OnPong == nil {
 		c.OnPong = func(m *gqlwsmessage.Message) {}
 	}
+	if c.OnRequest == nil {
+		c.OnRequest = func(r *http.Request) (ConnectionParams, error) { return nil, nil }
+	}
 }
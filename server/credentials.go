@@ -0,0 +1,100 @@
+package gqlwsserver
+
+import (
+	"time"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// expiringPayload is the ack payload of credentials valid until expiry
+type expiringPayload struct {
+	payload gqlwsmessage.Payload
+	expiry  time.Time
+}
+
+// ExpiresAt wraps the payload returned by OnConnectionInit so that the credentials of the socket expire at t.
+// the socket then closes with 4401 unless OnExpiry or a refresh from the client extends them
+func ExpiresAt(payload gqlwsmessage.Payload, t time.Time) gqlwsmessage.Payload {
+	return &expiringPayload{payload: payload, expiry: t}
+}
+
+// unwrapExpiry returns the ack payload and the expiry set by ExpiresAt, if any
+func unwrapExpiry(payload gqlwsmessage.Payload) (gqlwsmessage.Payload, time.Time) {
+	if p, ok := payload.(*expiringPayload); ok {
+		return p.payload, p.expiry
+	}
+	return payload, time.Time{}
+}
+
+// expireAt schedules the expiry of the credentials, replacing the previous one. the zero time never expires
+func (sock *Socket) expireAt(t time.Time) {
+	sock.expiryLock.Lock()
+	defer sock.expiryLock.Unlock()
+	if sock.expiry != nil {
+		sock.expiry.Stop()
+		sock.expiry = nil
+	}
+	if t.IsZero() || isClosed(sock.done) {
+		return
+	}
+	sock.expiry = time.AfterFunc(time.Until(t), sock.expire)
+}
+
+// expire closes the socket as unauthorized unless OnExpiry extends the credentials
+func (sock *Socket) expire() {
+	if sock.OnExpiry != nil {
+		if t, err := sock.OnExpiry(sock.ConnectionParams()); err == nil && t.After(time.Now()) {
+			sock.expireAt(t)
+			return
+		}
+	}
+	sock.fail(gqlwserror.NewCloseError(gqlwserror.CloseUnauthorized, `Credentials expired`))
+}
+
+// refresh hands the credentials of a ping to OnRefresh, closing the socket as unauthorized if they are rejected.
+// accepted credentials are stored in the connection params seen by the following operations and OnExpiry, and in those
+// of the session that a resuming client is authorized against
+func (sock *Socket) refresh(msg *gqlwsmessage.Message) {
+	payload, ok := msg.Payload.(map[string]interface{})
+	if !ok || sock.OnRefresh == nil {
+		return
+	}
+	credentials, ok := payload[gqlwsmessage.RefreshKey]
+	if !ok {
+		return
+	}
+	t, err := sock.OnRefresh(msg)
+	if err != nil {
+		panic(gqlwserror.NewCloseError(gqlwserror.CloseUnauthorized, err.Error()))
+	}
+	sock.paramsLock.Lock()
+	sock.connectionParams = refreshParams(sock.connectionParams, credentials)
+	params := sock.connectionParams
+	sock.paramsLock.Unlock()
+	// a client reconnecting after the refresh presents the new credentials
+	if session := sock.joined(); session != nil {
+		session.refresh(sock, params)
+	}
+	sock.expireAt(t)
+}
+
+// refreshParams returns a copy of params updated with credentials. the entries of a map replace those of params,
+// any other credentials are stored under gqlwsmessage.RefreshKey. params other than a map are replaced
+func refreshParams(params ConnectionParams, credentials interface{}) ConnectionParams {
+	refreshed := map[string]interface{}{}
+	if p, ok := params.(map[string]interface{}); ok {
+		for k, v := range p {
+			refreshed[k] = v
+		}
+	}
+	c, ok := credentials.(map[string]interface{})
+	if !ok {
+		refreshed[gqlwsmessage.RefreshKey] = credentials
+		return refreshed
+	}
+	for k, v := range c {
+		refreshed[k] = v
+	}
+	return refreshed
+}
@@ -0,0 +1,87 @@
+package gqlwsserver_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentials(t *testing.T) {
+	lifetime := time.Millisecond * 50
+	expiring := func(m *gqlwsmessage.Message) gqlwsmessage.Payload {
+		return gqlwsserver.ExpiresAt(map[string]interface{}{`user`: `me`}, time.Now().Add(lifetime))
+	}
+	query := gqlwsmessage.SubscribePayload{Query: `{q}`}
+	t.Run("closes once expired", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), OnConnectionInit: expiring})
+		ack := conn.Init(nil)
+		assert.Equal(t, map[string]interface{}{`user`: `me`}, ack.Payload)
+		start := time.Now()
+		conn.ExpectClose(gqlwserror.CloseUnauthorized)
+		assert.GreaterOrEqual(t, time.Since(start), lifetime/2)
+	})
+	t.Run("can be re-authenticated on expiry", func(t *testing.T) {
+		var expiries int32
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), OnConnectionInit: expiring, OnExpiry: func(params gqlwsserver.ConnectionParams) (time.Time, error) {
+			if atomic.AddInt32(&expiries, 1) > 1 {
+				return time.Time{}, errors.New(`revoked`)
+			}
+			return time.Now().Add(lifetime), nil
+		}})
+		conn.Init(nil)
+		time.Sleep(lifetime * 3 / 2)
+		id := conn.Subscribe(query)
+		conn.ExpectNext(id)
+		conn.ExpectComplete(id)
+		conn.ExpectClose(gqlwserror.CloseUnauthorized)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&expiries))
+	})
+	t.Run("can be refreshed by the client", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), OnConnectionInit: expiring, OnRefresh: func(m *gqlwsmessage.Message) (time.Time, error) {
+			if m.Payload.(map[string]interface{})[gqlwsmessage.RefreshKey] != `token` {
+				return time.Time{}, errors.New(`Invalid token`)
+			}
+			return time.Now().Add(lifetime * 4), nil
+		}})
+		conn.Init(nil)
+		conn.Ping(map[string]interface{}{gqlwsmessage.RefreshKey: `token`})
+		conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		time.Sleep(lifetime * 2)
+		id := conn.Subscribe(query)
+		conn.ExpectNext(id)
+		conn.ExpectComplete(id)
+		conn.Ping(map[string]interface{}{gqlwsmessage.RefreshKey: `forged`})
+		conn.ExpectClose(gqlwserror.CloseUnauthorized)
+	})
+	t.Run("passes refreshed credentials to resolvers and OnExpiry", func(t *testing.T) {
+		expired := make(chan gqlwsserver.ConnectionParams, 1)
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), OnConnectionInit: expiring,
+			OnRefresh: func(m *gqlwsmessage.Message) (time.Time, error) { return time.Now().Add(lifetime), nil },
+			OnExpiry: func(params gqlwsserver.ConnectionParams) (time.Time, error) {
+				expired <- params
+				return time.Time{}, errors.New(`revoked`)
+			},
+		})
+		conn.Init(map[string]interface{}{`user`: `me`, `token`: `old`})
+		conn.Ping(map[string]interface{}{gqlwsmessage.RefreshKey: map[string]interface{}{`token`: `new`}})
+		conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{p}`})
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{`p`: `map[token:new user:me]`}))
+		conn.ExpectComplete(id)
+		conn.ExpectClose(gqlwserror.CloseUnauthorized)
+		assert.Equal(t, map[string]interface{}{`user`: `me`, `token`: `new`}, <-expired)
+	})
+	t.Run("does not expire by default", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		conn.Init(nil)
+		time.Sleep(lifetime * 2)
+		conn.ExpectNext(conn.Subscribe(query))
+	})
+}
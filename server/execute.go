@@ -0,0 +1,116 @@
+package gqlwsserver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+var errOperationTimeout = errors.New(`Operation timed out`)
+
+// execute runs q and hands every result to emit until the operation ends, emit fails or stop is closed.
+// it is shared by all transports. a query or mutation exceeding its timeout returns errOperationTimeout,
+// while a subscription or live query exceeding its lifetime ends normally
+func (c *Config) execute(params ConnectionParams, q *gqlwsmessage.SubscribePayload, stop chan interface{}, emit func(*graphql.Result) error) error {
+	q, live := c.liveQuery(q)
+	if res := c.validate(params, q); res != nil {
+		return emit(res)
+	}
+	opType := getSelectedOperationType(q)
+	gqlParams, cancel := c.getGqlParams(params, q, stop)
+	defer cancel()
+	timed := opType
+	if live {
+		timed = ast.OperationTypeSubscription
+	}
+	timeout := c.OperationTimeoutFunc(params, q, timed)
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(gqlParams.Context, timeout)
+		defer cancel()
+		gqlParams.Context = ctx
+	}
+	if live {
+		return c.executeLive(gqlParams, stop, emit)
+	}
+	if opType != ast.OperationTypeSubscription {
+		if timeout <= 0 {
+			return emit(graphql.Do(*gqlParams))
+		}
+		return doWithin(gqlParams, stop, emit)
+	}
+	// the channel is closed once the context is done
+	reschan := graphql.Subscribe(*gqlParams)
+	for res := range reschan {
+		if err := emit(res); err != nil {
+			// the source stops once stop is closed. keep it from blocking until then
+			go func() {
+				for range reschan {
+				}
+			}()
+			return err
+		}
+	}
+	return nil
+}
+
+// doWithin gives up on the operation once it is stopped or its context is done. the resolvers are left to notice it on their own
+func doWithin(p *graphql.Params, stop chan interface{}, emit func(*graphql.Result) error) error {
+	result := make(chan *graphql.Result, 1)
+	go func() { result <- graphql.Do(*p) }()
+	select {
+	case res := <-result:
+		return emit(res)
+	case <-stop:
+		return nil
+	case <-p.Context.Done():
+		if p.Context.Err() == context.DeadlineExceeded {
+			return errOperationTimeout
+		}
+		return p.Context.Err()
+	}
+}
+
+// executeHTTP is execute for the HTTP transports, which report a timeout as a result
+func (c *Config) executeHTTP(params ConnectionParams, q *gqlwsmessage.SubscribePayload, stop chan interface{}, emit func(*graphql.Result) error) error {
+	err := c.execute(params, q, stop, emit)
+	if err == errOperationTimeout {
+		return emit(&graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+	}
+	return err
+}
+
+// defaultOperationTimeout applies OperationTimeout to queries and mutations and SubscriptionLifetime to subscriptions
+func (c *Config) defaultOperationTimeout(params ConnectionParams, q *gqlwsmessage.SubscribePayload, operation string) time.Duration {
+	if operation == ast.OperationTypeSubscription {
+		return c.SubscriptionLifetime
+	}
+	return c.OperationTimeout
+}
+
+// getGqlParams returns the params executing q. their context is cancelled once stop is closed or cancel is called
+func (c *Config) getGqlParams(params ConnectionParams, q *gqlwsmessage.SubscribePayload, stop chan interface{}) (*graphql.Params, context.CancelFunc) {
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.WithValue(ctx, connParamsKey, params), subscriptionStopKey, stop))
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return &graphql.Params{
+		Schema:         *c.Schema,
+		RequestString:  q.Query,
+		VariableValues: q.Variables,
+		OperationName:  q.OperationName,
+		Context:        ctx,
+	}, cancel
+}
@@ -0,0 +1,120 @@
+package gqlwsserver_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationTimeout(t *testing.T) {
+	// holds up the stuck query until the test ends
+	release := make(chan interface{})
+	defer close(release)
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"slow": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						<-p.Context.Done()
+						return nil, p.Context.Err()
+					},
+				},
+				"stuck": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						<-release
+						return nil, nil
+					},
+				},
+			},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Subscription`,
+			Fields: graphql.Fields{
+				"forever": &graphql.Field{
+					Type:    graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source, nil },
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						c := make(chan interface{})
+						go func() {
+							defer close(c)
+							select {
+							case c <- `started`:
+							case <-p.Context.Done():
+								return
+							}
+							<-p.Context.Done()
+						}()
+						return c, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	t.Run("answers a slow query with an error", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema, OperationTimeout: time.Millisecond * 10})
+		conn.Init(nil)
+		conn.ExpectError(conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{slow}`}), gqlwstest.WithErrors(`Operation timed out`))
+	})
+	t.Run("completes a subscription after its lifetime", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema, SubscriptionLifetime: time.Millisecond * 10})
+		conn.Init(nil)
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{forever}`})
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{`forever`: `started`}))
+		conn.ExpectComplete(id)
+	})
+	t.Run("can be overridden per operation", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema, OperationTimeout: time.Hour, OperationTimeoutFunc: func(params gqlwsserver.ConnectionParams, q *gqlwsmessage.SubscribePayload, operation string) time.Duration {
+			if operation == ast.OperationTypeQuery && q.OperationName == `Slow` {
+				return time.Millisecond * 10
+			}
+			return 0
+		}})
+		conn.Init(nil)
+		conn.ExpectError(conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `query Slow{slow}`, OperationName: `Slow`}), gqlwstest.WithErrors(`Operation timed out`))
+	})
+	t.Run("reports a timeout over HTTP", func(t *testing.T) {
+		server := httptest.NewServer(gqlwsserver.NewHTTPHandler(&gqlwsserver.Config{Schema: &schema, OperationTimeout: time.Millisecond * 10}))
+		defer server.Close()
+		for accept, status := range map[string]int{`application/json`: http.StatusOK, `application/graphql-response+json`: http.StatusGatewayTimeout} {
+			req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"query":"{slow}"}`))
+			assert.Nil(t, err)
+			req.Header.Set(`Content-Type`, `application/json`)
+			req.Header.Set(`Accept`, accept)
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.Nil(t, err)
+			assert.Equal(t, status, res.StatusCode, accept)
+			assert.Contains(t, string(body), `Operation timed out`)
+		}
+	})
+	t.Run("fails over HTTP when the operation ends without a result", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		server := httptest.NewServer(gqlwsserver.NewHTTPHandler(&gqlwsserver.Config{Schema: &schema, Context: ctx, OperationTimeout: time.Hour}))
+		defer server.Close()
+		res, err := http.Post(server.URL, `application/json`, strings.NewReader(`{"query":"{stuck}"}`))
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+		assert.Contains(t, string(body), context.Canceled.Error())
+	})
+}
@@ -0,0 +1,64 @@
+package gqlwsserver_test
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+)
+
+// FuzzSocket feeds newline separated frames to a socket. the socket must end without leaking goroutines
+// and close with a code of the protocol
+func FuzzSocket(f *testing.F) {
+	schema := gqlwstest.Schema(f)
+	sanctioned := map[int]bool{
+		gqlwserror.CloseNormal:              true,
+		gqlwserror.CloseBadRequest:          true,
+		gqlwserror.CloseUnauthorized:        true,
+		gqlwserror.CloseInitTimeout:         true,
+		gqlwserror.CloseSubscriberExists:    true,
+		gqlwserror.CloseTooManyInitRequests: true,
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		goroutines := runtime.NumGoroutine()
+		tr := gqlwstest.NewFrameTransport(bytes.Split(data, []byte("\n")))
+		sock := make(chan *gqlwsserver.Socket)
+		go func() {
+			sock <- gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: tr, Schema: schema, ConnectionInitTimeout: time.Millisecond * 10})
+		}()
+		var s *gqlwsserver.Socket
+		select {
+		case s = <-sock:
+		case <-time.After(time.Second * 5):
+			t.Fatal(`deadlocked on init`)
+		}
+		// the frames are exhausted by now unless a subscription is running
+		time.Sleep(time.Millisecond)
+		s.Close()
+		done := make(chan interface{})
+		go func() {
+			s.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			t.Fatal(`deadlocked on close`)
+		}
+		if !sanctioned[tr.Code()] {
+			t.Fatalf(`closed with %v: %v`, tr.Code(), s.Error())
+		}
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > goroutines {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("leaked %v goroutines\n%s", runtime.NumGoroutine()-goroutines, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
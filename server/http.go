@@ -0,0 +1,238 @@
+package gqlwsserver
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+var errResponded = errors.New(`Responded`)
+
+const (
+	mediaTypeGraphQLResponse = `application/graphql-response+json`
+	mediaTypeJSON            = `application/json`
+)
+
+// HTTPHandler serves queries and mutations over GraphQL-over-HTTP, and subscriptions over the Apollo multipart protocol.
+// websocket upgrade requests on the same route are handed to a Socket built from the same Config
+type HTTPHandler struct {
+	*Config
+}
+
+func NewHTTPHandler(cfg *Config) *HTTPHandler {
+	var h HTTPHandler
+	cfg.init()
+	h.Config = cfg
+	return &h
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get(`Upgrade`), `websocket`) {
+		cfg := *h.Config
+		cfg.Response = w
+		cfg.Request = r
+		NewSocket(&cfg).Wait()
+		return
+	}
+	mediaType := negotiateResponseType(r.Header.Get(`Accept`))
+	multipart := acceptsMultipart(r.Header.Get(`Accept`))
+	onlyMultipart := mediaType == ``
+	if onlyMultipart {
+		if !multipart {
+			http.Error(w, `Accept must include `+mediaTypeGraphQLResponse+` or `+mediaTypeJSON, http.StatusNotAcceptable)
+			return
+		}
+		// errors raised before streaming starts
+		mediaType = mediaTypeJSON
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get(`Content-Type`)); ct != mediaTypeJSON {
+			http.Error(w, `Content-Type must be `+mediaTypeJSON, http.StatusUnsupportedMediaType)
+			return
+		}
+	default:
+		w.Header().Set(`Allow`, `GET, POST`)
+		http.Error(w, `Method not allowed`, http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := h.readRequest(w, r)
+	if err != nil {
+		h.writeResult(w, mediaType, http.StatusBadRequest, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+	if rejectMutationOverGET(w, r, q) {
+		return
+	}
+	opType := getSelectedOperationType(q)
+	if opType == ast.OperationTypeSubscription && !multipart {
+		h.writeResult(w, mediaType, http.StatusBadRequest, &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New(`Subscriptions require multipart/mixed`))})
+		return
+	}
+	params, err := h.OnRequest(r)
+	if err != nil {
+		h.writeResult(w, mediaType, http.StatusUnauthorized, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+	if _, live := h.liveQuery(q); live && multipart {
+		// the results of a live query are streamed like those of a subscription
+		opType = ast.OperationTypeSubscription
+	}
+	if multipart && (onlyMultipart || opType == ast.OperationTypeSubscription) {
+		h.serveMultipart(w, r, q, params)
+		return
+	}
+	if res := h.check(params, q); res != nil {
+		// application/json answers every well-formed request with 200
+		status := http.StatusOK
+		if mediaType == mediaTypeGraphQLResponse {
+			status = http.StatusBadRequest
+		}
+		h.writeResult(w, mediaType, status, res)
+		return
+	}
+	stop := make(chan interface{})
+	defer close(stop)
+	type outcome struct {
+		res *graphql.Result
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		var o outcome
+		o.err = h.execute(params, q, stop, func(r *graphql.Result) error {
+			o.res = r
+			// a single result is sent, which ends a live query
+			return errResponded
+		})
+		done <- o
+	}()
+	var o outcome
+	select {
+	case o = <-done:
+	case <-r.Context().Done():
+		// the client is gone. closing stop cancels the context of the operation
+		return
+	}
+	res, err := o.res, o.err
+	status := http.StatusOK
+	switch {
+	case err == errOperationTimeout:
+		res = &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+		if mediaType == mediaTypeGraphQLResponse {
+			status = http.StatusGatewayTimeout
+		}
+	case res == nil:
+		// the operation ended before producing a result, e.g. once Config.Context is cancelled
+		if err == nil {
+			err = errors.New(`Operation ended without a result`)
+		}
+		h.writeResult(w, mediaType, http.StatusInternalServerError, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+	h.writeResult(w, mediaType, status, res)
+}
+
+// check parses and validates q before it is executed, applying the rules of the connection on top of those of graphql.
+// returns the result rejecting q, or nil to execute it. errors raised by the execution itself never reject q
+func (h *HTTPHandler) check(params ConnectionParams, q *gqlwsmessage.SubscribePayload) *graphql.Result {
+	q, _ = h.liveQuery(q)
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(q.Query), Name: `GraphQL request`})})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	rules := graphql.SpecifiedRules
+	if !h.introspectionAllowed(params) {
+		rules = append(append([]graphql.ValidationRuleFn{}, rules...), noIntrospectionRule)
+	}
+	if res := graphql.ValidateDocument(h.Schema, doc, rules); !res.IsValid {
+		return &graphql.Result{Errors: res.Errors}
+	}
+	return nil
+}
+
+func (h *HTTPHandler) writeResult(w http.ResponseWriter, mediaType string, status int, res *graphql.Result) {
+	data, err := h.Codec.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, mediaType+`; charset=utf-8`)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// negotiateResponseType picks the response media type. requests without Accept are answered in application/json
+func negotiateResponseType(accept string) string {
+	if accept == `` {
+		return mediaTypeJSON
+	}
+	var fallback string
+	for _, part := range strings.Split(accept, `,`) {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case mediaTypeGraphQLResponse, `*/*`, `application/*`:
+			return mediaTypeGraphQLResponse
+		case mediaTypeJSON:
+			fallback = mediaTypeJSON
+		}
+	}
+	return fallback
+}
+
+// rejectMutationOverGET answers a GET selecting a mutation with 405, as GET must not have side effects.
+// returns whether it did
+func rejectMutationOverGET(w http.ResponseWriter, r *http.Request, q *gqlwsmessage.SubscribePayload) bool {
+	if r.Method != http.MethodGet || getSelectedOperationType(q) != ast.OperationTypeMutation {
+		return false
+	}
+	w.Header().Set(`Allow`, `POST`)
+	http.Error(w, `Mutations cannot be executed over GET`, http.StatusMethodNotAllowed)
+	return true
+}
+
+// readRequest reads the operation from the URL of GET requests or from the body otherwise
+func (c *Config) readRequest(w http.ResponseWriter, r *http.Request) (*gqlwsmessage.SubscribePayload, error) {
+	var q gqlwsmessage.SubscribePayload
+	if r.Method == http.MethodGet {
+		values := r.URL.Query()
+		q.Query = values.Get(`query`)
+		q.OperationName = values.Get(`operationName`)
+		for key, v := range map[string]*map[string]interface{}{`variables`: &q.Variables, `extensions`: &q.Extensions} {
+			if raw := values.Get(key); raw != `` {
+				if err := c.Codec.Unmarshal([]byte(raw), v); err != nil {
+					return nil, fmt.Errorf(`%v are invalid`, key)
+				}
+			}
+		}
+	} else {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, c.MaxRequestBodySize))
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Codec.Unmarshal(body, &q); err != nil {
+			return nil, errors.New(`Request body is invalid`)
+		}
+	}
+	if err := c.resolveDocument(&q); err != nil {
+		return nil, err
+	}
+	if q.Query == `` {
+		return nil, errors.New(`Query is missing`)
+	}
+	return &q, nil
+}
@@ -0,0 +1,305 @@
+package gqlwsserver_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	handler := gqlwsserver.NewHTTPHandler(&gqlwsserver.Config{
+		Schema: gqlwstest.Schema(t),
+		OnRequest: func(r *http.Request) (gqlwsserver.ConnectionParams, error) {
+			return r.Header.Get(`Authorization`), nil
+		},
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	do := func(method, target, accept, body string) (*http.Response, string) {
+		req, err := http.NewRequest(method, server.URL+target, strings.NewReader(body))
+		assert.Nil(t, err)
+		req.Header.Set(`Accept`, accept)
+		req.Header.Set(`Content-Type`, `application/json`)
+		req.Header.Set(`Authorization`, `token`)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		return res, string(data)
+	}
+	graphqlResponse := `application/graphql-response+json`
+	t.Run("can query with POST", func(t *testing.T) {
+		res, body := do(http.MethodPost, ``, graphqlResponse, `{"query":"query{q p}"}`)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, graphqlResponse+`; charset=utf-8`, res.Header.Get(`Content-Type`))
+		assert.JSONEq(t, `{"data":{"q":"hi","p":"token"}}`, body)
+	})
+	t.Run("can query with GET", func(t *testing.T) {
+		res, body := do(http.MethodGet, `?query=`+url.QueryEscape(`query{q}`), `application/json`, ``)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, `application/json; charset=utf-8`, res.Header.Get(`Content-Type`))
+		assert.JSONEq(t, `{"data":{"q":"hi"}}`, body)
+	})
+	t.Run("invalid document", func(t *testing.T) {
+		res, _ := do(http.MethodPost, ``, graphqlResponse, `{"query":"query{unknown}"}`)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		res, _ = do(http.MethodPost, ``, `application/json`, `{"query":"query{unknown}"}`)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+	t.Run("malformed request", func(t *testing.T) {
+		res, _ := do(http.MethodPost, ``, graphqlResponse, `{`)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+	t.Run("rejects oversized body", func(t *testing.T) {
+		res, _ := do(http.MethodPost, ``, graphqlResponse, `{"query":"query{q}","variables":{"v":"`+strings.Repeat(`a`, 2<<20)+`"}}`)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+	t.Run("rejects mutation over GET", func(t *testing.T) {
+		res, _ := do(http.MethodGet, `?query=`+url.QueryEscape(`mutation{m}`), graphqlResponse, ``)
+		assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	})
+	t.Run("rejects unacceptable media type", func(t *testing.T) {
+		res, _ := do(http.MethodPost, ``, `text/html`, `{"query":"query{q}"}`)
+		assert.Equal(t, http.StatusNotAcceptable, res.StatusCode)
+	})
+	t.Run("multipart", func(t *testing.T) {
+		stream := func(query, accept string) (*http.Response, *multipart.Reader) {
+			req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(fmt.Sprintf(`{"query":%q}`, query)))
+			assert.Nil(t, err)
+			req.Header.Set(`Accept`, accept)
+			req.Header.Set(`Content-Type`, `application/json`)
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			mediaType, params, err := mime.ParseMediaType(res.Header.Get(`Content-Type`))
+			assert.Nil(t, err)
+			assert.Equal(t, `multipart/mixed`, mediaType)
+			return res, multipart.NewReader(res.Body, params[`boundary`])
+		}
+		readPart := func(r *multipart.Reader) string {
+			part, err := r.NextPart()
+			assert.Nil(t, err)
+			data, err := ioutil.ReadAll(part)
+			assert.Nil(t, err)
+			return string(data)
+		}
+		t.Run("can subscribe", func(t *testing.T) {
+			res, parts := stream(`subscription{s}`, `multipart/mixed;subscriptionSpec="1.0", application/json`)
+			defer res.Body.Close()
+			for i := 0; i < 10; i++ {
+				assert.JSONEq(t, `{"payload":{"data":{"s":"hi"}}}`, readPart(parts))
+			}
+		})
+		t.Run("ends after query", func(t *testing.T) {
+			res, parts := stream(`query{q}`, `multipart/mixed;subscriptionSpec="1.0"`)
+			defer res.Body.Close()
+			assert.JSONEq(t, `{"payload":{"data":{"q":"hi"}}}`, readPart(parts))
+			_, err := parts.NextPart()
+			assert.Equal(t, io.EOF, err)
+		})
+	})
+	t.Run("shares route with websocket", func(t *testing.T) {
+		uri, err := url.Parse(server.URL)
+		assert.Nil(t, err)
+		uri.Scheme = `ws`
+		conn, _, err := websocket.DefaultDialer.Dial(uri.String(), http.Header{"Sec-WebSocket-Protocol": []string{`graphql-transport-ws`}})
+		assert.Nil(t, err)
+		defer conn.Close()
+		assert.Nil(t, conn.WriteJSON(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit}))
+		var msg gqlwsmessage.Message
+		assert.Nil(t, conn.ReadJSON(&msg))
+		assert.Equal(t, gqlwsmessage.ConnectionAck, msg.Type)
+	})
+}
+
+func TestHTTPStatus(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"fail": &graphql.Field{
+					Type:    graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) { return nil, errors.New(`failed`) },
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	server := httptest.NewServer(gqlwsserver.NewHTTPHandler(&gqlwsserver.Config{Schema: &schema, DisableIntrospection: true}))
+	defer server.Close()
+	status := func(query string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(fmt.Sprintf(`{"query":%q}`, query)))
+		assert.Nil(t, err)
+		req.Header.Set(`Accept`, `application/graphql-response+json`)
+		req.Header.Set(`Content-Type`, `application/json`)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	t.Run("rejects a document failing to parse", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, status(`{fail`))
+	})
+	t.Run("rejects a document failing the rules of the connection", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, status(`{__schema{queryType{name}}}`))
+	})
+	t.Run("answers an execution without data with 200", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, status(`{fail}`))
+	})
+}
+
+func TestHTTPDisconnect(t *testing.T) {
+	// receives the name of every field whose context was cancelled
+	cancelled := make(chan string, 3)
+	started := make(chan interface{}, 1)
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"hang": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						started <- nil
+						<-p.Context.Done()
+						cancelled <- `hang`
+						return nil, p.Context.Err()
+					},
+				},
+			},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Subscription`,
+			Fields: graphql.Fields{
+				"watch": &graphql.Field{
+					Type:    graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source, nil },
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						c := make(chan interface{})
+						go func() {
+							defer close(c)
+							select {
+							case c <- `started`:
+							case <-p.Context.Done():
+							}
+							<-p.Context.Done()
+							cancelled <- `watch`
+						}()
+						return c, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	cfg := gqlwsserver.Config{Schema: &schema}
+	httpServer := httptest.NewServer(gqlwsserver.NewHTTPHandler(&cfg))
+	defer httpServer.Close()
+	sseServer := httptest.NewServer(gqlwsserver.NewSSEHandler(&cfg))
+	defer sseServer.Close()
+	// disconnect sends the request and cancels it once ready returns
+	disconnect := func(t *testing.T, target, accept, query string, ready func(*http.Response)) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(fmt.Sprintf(`{"query":%q}`, query)))
+		assert.Nil(t, err)
+		req.Header.Set(`Accept`, accept)
+		req.Header.Set(`Content-Type`, `application/json`)
+		go func() {
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return
+			}
+			defer res.Body.Close()
+			ready(res)
+			cancel()
+		}()
+		select {
+		case <-ctx.Done():
+		case <-started:
+			cancel()
+		case <-time.After(time.Second):
+			t.Fatal(`the operation did not start`)
+		}
+	}
+	expectCancelled := func(t *testing.T, field string) {
+		select {
+		case got := <-cancelled:
+			assert.Equal(t, field, got)
+		case <-time.After(time.Second):
+			t.Fatalf(`%s was not cancelled`, field)
+		}
+	}
+	t.Run("stops a query", func(t *testing.T) {
+		disconnect(t, httpServer.URL, `application/graphql-response+json`, `query{hang}`, func(*http.Response) {})
+		expectCancelled(t, `hang`)
+	})
+	t.Run("stops a multipart subscription", func(t *testing.T) {
+		disconnect(t, httpServer.URL, `multipart/mixed;subscriptionSpec="1.0"`, `subscription{watch}`, func(res *http.Response) {
+			_, params, err := mime.ParseMediaType(res.Header.Get(`Content-Type`))
+			assert.Nil(t, err)
+			// the headers of the first part are followed by its result
+			_, err = multipart.NewReader(res.Body, params[`boundary`]).NextPart()
+			assert.Nil(t, err)
+		})
+		expectCancelled(t, `watch`)
+	})
+	t.Run("stops an SSE subscription", func(t *testing.T) {
+		disconnect(t, sseServer.URL, `text/event-stream`, `subscription{watch}`, func(res *http.Response) {
+			line, err := bufio.NewReader(res.Body).ReadString('\n')
+			assert.Nil(t, err)
+			assert.Equal(t, "event: next\n", line)
+		})
+		expectCancelled(t, `watch`)
+	})
+}
+
+func TestHTTPSelectedOperation(t *testing.T) {
+	var mutations int32
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   `Query`,
+			Fields: graphql.Fields{"q": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) { return `hi`, nil }}},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Mutation`,
+			Fields: graphql.Fields{"m": &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return atomic.AddInt32(&mutations, 1), nil
+			}}},
+		}),
+	})
+	assert.Nil(t, err)
+	server := httptest.NewServer(gqlwsserver.NewHTTPHandler(&gqlwsserver.Config{Schema: &schema}))
+	defer server.Close()
+	get := func(operationName string) int {
+		res, err := http.Get(server.URL + `?query=` + url.QueryEscape(`query A{q} mutation B{m}`) + `&operationName=` + operationName)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	t.Run("rejects the mutation selected over GET", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, get(`B`))
+		assert.Zero(t, atomic.LoadInt32(&mutations))
+	})
+	t.Run("executes the query selected over GET", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, get(`A`))
+	})
+}
@@ -0,0 +1,64 @@
+package gqlwsserver
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/kinds"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/graphql/language/visitor"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// introspectionAllowed decides whether a connection with params may introspect the schema
+func (c *Config) introspectionAllowed(params ConnectionParams) bool {
+	if c.AllowIntrospection != nil {
+		return c.AllowIntrospection(params)
+	}
+	return !c.DisableIntrospection
+}
+
+// validate applies the rules of the connection on top of those of graphql.
+// returns the result rejecting q, or nil to execute it. syntax errors are left to graphql to report
+func (c *Config) validate(params ConnectionParams, q *gqlwsmessage.SubscribePayload) *graphql.Result {
+	var rules []graphql.ValidationRuleFn
+	if !c.introspectionAllowed(params) {
+		rules = append(rules, noIntrospectionRule)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(q.Query), Name: `GraphQL request`})})
+	if err != nil {
+		return nil
+	}
+	if res := graphql.ValidateDocument(c.Schema, doc, rules); !res.IsValid {
+		return &graphql.Result{Errors: res.Errors}
+	}
+	return nil
+}
+
+// noIntrospectionRule rejects the __schema and __type fields. __typename is still allowed
+func noIntrospectionRule(context *graphql.ValidationContext) *graphql.ValidationRuleInstance {
+	return &graphql.ValidationRuleInstance{
+		VisitorOpts: &visitor.VisitorOptions{
+			KindFuncMap: map[string]visitor.NamedVisitFuncs{
+				kinds.Field: {
+					Kind: func(p visitor.VisitFuncParams) (string, interface{}) {
+						if node, ok := p.Node.(*ast.Field); ok && node.Name != nil {
+							switch node.Name.Value {
+							case `__schema`, `__type`:
+								context.ReportError(gqlerrors.NewError(
+									`GraphQL introspection is not allowed, but the query contained `+node.Name.Value,
+									[]ast.Node{node}, ``, nil, []int{}, nil,
+								))
+							}
+						}
+						return visitor.ActionNoChange, nil
+					},
+				},
+			},
+		},
+	}
+}
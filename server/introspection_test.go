@@ -0,0 +1,57 @@
+package gqlwsserver_test
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntrospection(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"q": &graphql.Field{
+					Type:    graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) { return `hi`, nil },
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	schemaQuery := gqlwsmessage.SubscribePayload{Query: `{__schema{queryType{name}}}`}
+	typeQuery := gqlwsmessage.SubscribePayload{Query: `query{...F} fragment F on Query{__type(name:"Query"){name}}`}
+	t.Run("allowed by default", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema})
+		conn.Init(nil)
+		conn.ExpectNext(conn.Subscribe(schemaQuery), gqlwstest.WithData(map[string]interface{}{`__schema`: map[string]interface{}{`queryType`: map[string]interface{}{`name`: `Query`}}}))
+	})
+	t.Run("can be disabled", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema, DisableIntrospection: true})
+		conn.Init(nil)
+		for _, q := range []gqlwsmessage.SubscribePayload{schemaQuery, typeQuery} {
+			id := conn.Subscribe(q)
+			conn.ExpectNext(id, gqlwstest.WithErrors(`introspection is not allowed`))
+			conn.ExpectComplete(id)
+		}
+		conn.ExpectNext(conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{__typename q}`}), gqlwstest.WithData(map[string]interface{}{`__typename`: `Query`, `q`: `hi`}))
+	})
+	t.Run("can be decided per connection", func(t *testing.T) {
+		cfg := func() *gqlwsserver.Config {
+			return &gqlwsserver.Config{Schema: &schema, AllowIntrospection: func(params gqlwsserver.ConnectionParams) bool {
+				p, _ := params.(map[string]interface{})
+				return p[`role`] == `admin`
+			}}
+		}
+		conn := gqlwstest.Serve(t, cfg())
+		conn.Init(nil)
+		conn.ExpectNext(conn.Subscribe(schemaQuery), gqlwstest.WithErrors(`introspection is not allowed`))
+		conn = gqlwstest.Serve(t, cfg())
+		conn.Init(map[string]interface{}{`role`: `admin`})
+		conn.ExpectNext(conn.Subscribe(typeQuery), gqlwstest.WithData(map[string]interface{}{`__type`: map[string]interface{}{`name`: `Query`}}))
+	})
+}
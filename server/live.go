@@ -0,0 +1,180 @@
+package gqlwsserver
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/source"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// LiveDirective marks a query to be kept open and executed again whenever the resources it touched are invalidated
+const LiveDirective = `live`
+
+// LiveQueries runs the queries marked with @live. resolvers report the resources they read with Touch,
+// and Invalidate executes the live queries depending on them again, sending a result when it changed
+type LiveQueries struct {
+	lock     sync.Mutex
+	watchers map[*liveWatcher]struct{}
+}
+
+func NewLiveQueries() *LiveQueries {
+	var lq LiveQueries
+	lq.watchers = make(map[*liveWatcher]struct{})
+	return &lq
+}
+
+// Invalidate executes again the live queries that touched any of keys, e.g. User:42
+func (lq *LiveQueries) Invalidate(keys ...string) {
+	lq.lock.Lock()
+	defer lq.lock.Unlock()
+	for w := range lq.watchers {
+		w.invalidate(keys)
+	}
+}
+
+func (lq *LiveQueries) watch() *liveWatcher {
+	w := &liveWatcher{invalidated: make(chan interface{}, 1)}
+	lq.lock.Lock()
+	lq.watchers[w] = struct{}{}
+	lq.lock.Unlock()
+	return w
+}
+
+func (lq *LiveQueries) unwatch(w *liveWatcher) {
+	lq.lock.Lock()
+	delete(lq.watchers, w)
+	lq.lock.Unlock()
+}
+
+// liveWatcher follows the resources touched by the last execution of a live query
+type liveWatcher struct {
+	lock sync.Mutex
+	keys map[string]struct{}
+	// any invalidation during an execution counts, as the resources it touches are not known yet
+	running bool
+	// holds a pending invalidation
+	invalidated chan interface{}
+}
+
+func (w *liveWatcher) start() {
+	w.lock.Lock()
+	w.running = true
+	w.lock.Unlock()
+}
+
+func (w *liveWatcher) finish(keys map[string]struct{}) {
+	w.lock.Lock()
+	w.running = false
+	w.keys = keys
+	w.lock.Unlock()
+}
+
+func (w *liveWatcher) invalidate(keys []string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	hit := w.running
+	for _, key := range keys {
+		if _, ok := w.keys[key]; ok {
+			hit = true
+		}
+	}
+	if !hit {
+		return
+	}
+	select {
+	case w.invalidated <- nil:
+	default:
+	}
+}
+
+// liveRun collects the resources touched by an execution
+type liveRun struct {
+	lock sync.Mutex
+	keys map[string]struct{}
+}
+
+// Touch records that the live query being resolved depends on the resources of keys. it does nothing outside live queries
+func Touch(ctx context.Context, keys ...string) {
+	run, ok := ctx.Value(liveRunKey).(*liveRun)
+	if !ok {
+		return
+	}
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	for _, key := range keys {
+		run.keys[key] = struct{}{}
+	}
+}
+
+// liveQuery returns q without its @live directive if it is a live query.
+// returns q itself otherwise, including when live queries are disabled
+func (c *Config) liveQuery(q *gqlwsmessage.SubscribePayload) (*gqlwsmessage.SubscribePayload, bool) {
+	if c.LiveQueries == nil {
+		return q, false
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(q.Query), Name: `GraphQL request`})})
+	if err != nil {
+		return q, false
+	}
+	live := false
+	for _, node := range doc.Definitions {
+		op, ok := node.(*ast.OperationDefinition)
+		if !ok || op.Operation != ast.OperationTypeQuery {
+			continue
+		}
+		if q.OperationName != `` && (op.Name == nil || op.Name.Value != q.OperationName) {
+			continue
+		}
+		var directives []*ast.Directive
+		for _, d := range op.Directives {
+			if d.Name != nil && d.Name.Value == LiveDirective {
+				live = true
+				continue
+			}
+			directives = append(directives, d)
+		}
+		op.Directives = directives
+	}
+	if !live {
+		return q, false
+	}
+	stripped := *q
+	stripped.Query, _ = printer.Print(doc).(string)
+	return &stripped, true
+}
+
+// executeLive executes a live query again on every invalidation of the resources it touched until stop is closed.
+// a result is only emitted if it differs from the previous one
+func (c *Config) executeLive(p *graphql.Params, stop chan interface{}, emit func(*graphql.Result) error) error {
+	w := c.LiveQueries.watch()
+	defer c.LiveQueries.unwatch(w)
+	var last []byte
+	for {
+		run := &liveRun{keys: make(map[string]struct{})}
+		params := *p
+		params.Context = context.WithValue(p.Context, liveRunKey, run)
+		w.start()
+		res := graphql.Do(params)
+		w.finish(run.keys)
+		data, err := c.Codec.Marshal(res)
+		if err != nil || !bytes.Equal(data, last) {
+			last = data
+			if err := emit(res); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-w.invalidated:
+		case <-stop:
+			return nil
+		case <-p.Context.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package gqlwsserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveQueries(t *testing.T) {
+	var lock sync.Mutex
+	name := `alice`
+	setName := func(n string) {
+		lock.Lock()
+		defer lock.Unlock()
+		name = n
+	}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"user": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						gqlwsserver.Touch(p.Context, `User:42`)
+						lock.Lock()
+						defer lock.Unlock()
+						return name, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	t.Run("sends changed results on invalidation", func(t *testing.T) {
+		live := gqlwsserver.NewLiveQueries()
+		_, conn := serveSocket(t, &gqlwsserver.Config{Schema: &schema, LiveQueries: live})
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `query @live { user }`})
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"user": `alice`}))
+		// neither an unrelated resource nor an unchanged result yields a result
+		live.Invalidate(`User:1`)
+		live.Invalidate(`User:42`)
+		setName(`bob`)
+		live.Invalidate(`User:42`)
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"user": `bob`}))
+		conn.Complete(id)
+		setName(`alice`)
+	})
+	t.Run("answers plain queries once", func(t *testing.T) {
+		_, conn := serveSocket(t, &gqlwsserver.Config{Schema: &schema, LiveQueries: gqlwsserver.NewLiveQueries()})
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{ user }`})
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"user": `alice`}))
+		conn.ExpectComplete(id)
+	})
+	t.Run("requires LiveQueries", func(t *testing.T) {
+		_, conn := serveSocket(t, &gqlwsserver.Config{Schema: &schema})
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `query @live { user }`})
+		conn.ExpectNext(id, gqlwstest.WithErrors(`Unknown directive "live".`))
+		conn.ExpectComplete(id)
+	})
+	t.Run("answers HTTP requests with the current result", func(t *testing.T) {
+		server := httptest.NewServer(gqlwsserver.NewHTTPHandler(&gqlwsserver.Config{Schema: &schema, LiveQueries: gqlwsserver.NewLiveQueries()}))
+		defer server.Close()
+		body, _ := json.Marshal(gqlwsmessage.SubscribePayload{Query: `query @live { user }`})
+		res, err := http.Post(server.URL, `application/json`, bytes.NewReader(body))
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		var result map[string]interface{}
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&result))
+		assert.Equal(t, map[string]interface{}{"user": `alice`}, result["data"])
+	})
+}
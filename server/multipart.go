@@ -0,0 +1,89 @@
+package gqlwsserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+const (
+	multipartBoundary  = `graphql`
+	multipartHeartbeat = time.Second * 5
+)
+
+// acceptsMultipart reports whether the client speaks the Apollo multipart subscription protocol
+func acceptsMultipart(accept string) bool {
+	for _, part := range strings.Split(accept, `,`) {
+		if strings.HasPrefix(strings.TrimSpace(part), `multipart/mixed`) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveMultipart streams every result of the operation as a part of a multipart/mixed response,
+// with empty heartbeat parts in between, until the operation ends or the client disconnects
+func (h *HTTPHandler) serveMultipart(w http.ResponseWriter, r *http.Request, q *gqlwsmessage.SubscribePayload, params ConnectionParams) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, `Streaming unsupported`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, fmt.Sprintf(`multipart/mixed;boundary="%s";subscriptionSpec="1.0"`, multipartBoundary))
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "\r\n--%s", multipartBoundary); err != nil {
+		return
+	}
+	flusher.Flush()
+	writePart := func(part interface{}) error {
+		data, err := h.Codec.Marshal(part)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "\r\nContent-Type: application/json; charset=utf-8\r\n\r\n%s\r\n--%s", data, multipartBoundary); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	stop := make(chan interface{})
+	defer close(stop)
+	results := make(chan *graphql.Result)
+	go func() {
+		defer close(results)
+		h.executeHTTP(params, q, stop, func(res *graphql.Result) error {
+			select {
+			case results <- res:
+				return nil
+			case <-r.Context().Done():
+				return r.Context().Err()
+			}
+		})
+	}()
+	heartbeat := time.NewTicker(multipartHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				fmt.Fprint(w, "--\r\n")
+				flusher.Flush()
+				return
+			}
+			if writePart(map[string]interface{}{"payload": res}) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if writePart(map[string]interface{}{}) != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package gqlwsserver
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// Operation describes an operation running on a Socket
+type Operation struct {
+	ID   string `json:"id"`
+	Name string `json:"operationName,omitempty"`
+	// Type is query, mutation or subscription
+	Type string `json:"type"`
+	// QueryHash is the DocumentID of the query text
+	QueryHash string                 `json:"queryHash"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	StartedAt time.Time              `json:"startedAt"`
+	// Sent counts the next messages sent to the client
+	Sent uint64 `json:"sent"`
+}
+
+// Operations returns the operations running on the socket, oldest first. variables pass through RedactVariables
+func (sock *Socket) Operations() []Operation {
+	ops, _ := sock.operations()
+	var res []Operation
+	for id, op := range ops.list() {
+		vars := op.query.Variables
+		if vars != nil {
+			vars = sock.RedactVariables(vars)
+		}
+		res = append(res, Operation{
+			ID:        id,
+			Name:      op.query.OperationName,
+			Type:      getSelectedOperationType(op.query),
+			QueryHash: DocumentID(op.query.Query),
+			Variables: vars,
+			StartedAt: op.startedAt,
+			Sent:      atomic.LoadUint64(&op.sent),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].StartedAt.Before(res[j].StartedAt) })
+	return res
+}
+
+// ErrOperationNotFound is returned when ending an operation that is not running
+var ErrOperationNotFound = errors.New(`Operation not found`)
+
+// CompleteOperation stops the operation id and completes it once its queued results are sent
+func (sock *Socket) CompleteOperation(id string) error {
+	return sock.endOperation(id, &gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: &id})
+}
+
+// errOperationFailed answers an operation failed without any error, as an error message carries at least one
+var errOperationFailed = errors.New(`Operation failed`)
+
+// FailOperation stops the operation id and answers it with errs once its queued results are sent.
+// a generic error is sent if errs is empty
+func (sock *Socket) FailOperation(id string, errs ...error) error {
+	if len(errs) == 0 {
+		errs = []error{errOperationFailed}
+	}
+	return sock.endOperation(id, &gqlwsmessage.Message{Type: gqlwsmessage.Error, Payload: gqlerrors.FormatErrors(errs...), ID: &id})
+}
+
+func (sock *Socket) endOperation(id string, msg *gqlwsmessage.Message) error {
+	ops, _ := sock.operations()
+	if !ops.end(id, msg) {
+		return ErrOperationNotFound
+	}
+	return nil
+}
+
+// redactVariables keeps the names of the variables and hides their values
+func redactVariables(vars map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(vars))
+	for k := range vars {
+		redacted[k] = `[redacted]`
+	}
+	return redacted
+}
@@ -0,0 +1,171 @@
+package gqlwsserver_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketEndOperation(t *testing.T) {
+	// skip returns the first message following the results sent before the operation ended
+	skip := func(t *testing.T, conn *gqlwstest.Conn) *gqlwsmessage.Message {
+		for {
+			msg, err := conn.Receive()
+			assert.Nil(t, err)
+			if err != nil || msg.Type != gqlwsmessage.Next {
+				return msg
+			}
+		}
+	}
+	t.Run("completes an operation", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+		conn.ExpectNext(id)
+		assert.Nil(t, sock.CompleteOperation(id))
+		msg := skip(t, conn)
+		assert.Equal(t, gqlwsmessage.Complete, msg.Type)
+		assert.Equal(t, id, *msg.ID)
+		// the socket stays open
+		id = conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{q}`})
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"q": "hi"}))
+		conn.ExpectComplete(id)
+	})
+	t.Run("fails an operation", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+		conn.ExpectNext(id)
+		assert.Nil(t, sock.FailOperation(id, errors.New(`entity deleted`)))
+		msg := skip(t, conn)
+		assert.Equal(t, gqlwsmessage.Error, msg.Type)
+		assert.Equal(t, id, *msg.ID)
+		assert.Nil(t, gqlwstest.WithErrors(`entity deleted`)(msg))
+	})
+	t.Run("fails an operation without errors", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+		conn.ExpectNext(id)
+		assert.Nil(t, sock.FailOperation(id))
+		msg := skip(t, conn)
+		assert.Equal(t, gqlwsmessage.Error, msg.Type)
+		assert.Nil(t, gqlwstest.WithErrors(`Operation failed`)(msg))
+	})
+	t.Run("cancels the context of the operation", func(t *testing.T) {
+		cancelled := make(chan interface{})
+		schema, err := graphql.NewSchema(graphql.SchemaConfig{
+			Query: graphql.NewObject(graphql.ObjectConfig{Name: `Query`, Fields: graphql.Fields{"q": &graphql.Field{Type: graphql.String}}}),
+			Subscription: graphql.NewObject(graphql.ObjectConfig{
+				Name: `Subscription`,
+				Fields: graphql.Fields{
+					"endless": &graphql.Field{
+						Type:    graphql.String,
+						Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source, nil },
+						Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+							c := make(chan interface{})
+							// never ends on its own, and only watches its context
+							go func() {
+								defer close(c)
+								for {
+									select {
+									case c <- `tick`:
+									case <-p.Context.Done():
+										close(cancelled)
+										return
+									}
+								}
+							}()
+							return c, nil
+						},
+					},
+				},
+			}),
+		})
+		assert.Nil(t, err)
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: &schema})
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{endless}`})
+		conn.ExpectNext(id)
+		assert.Nil(t, sock.CompleteOperation(id))
+		msg := skip(t, conn)
+		assert.Equal(t, gqlwsmessage.Complete, msg.Type)
+		assert.Equal(t, id, *msg.ID)
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal(`source not cancelled`)
+		}
+	})
+	t.Run("rejects unknown operations", func(t *testing.T) {
+		sock, _ := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		assert.Equal(t, gqlwsserver.ErrOperationNotFound, sock.CompleteOperation(`unknown`))
+		assert.Equal(t, gqlwsserver.ErrOperationNotFound, sock.FailOperation(`unknown`))
+	})
+}
+
+func TestSocketClientComplete(t *testing.T) {
+	_, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), OperationBufferSize: 64})
+	id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+	conn.ExpectNext(id)
+	// results pile up while the conn is not read
+	time.Sleep(time.Millisecond * 100)
+	conn.Complete(id)
+	conn.Ping(nil)
+	// the results already written may precede the pong
+	for {
+		msg, err := conn.Receive()
+		if !assert.Nil(t, err) || msg.Type == gqlwsmessage.Pong {
+			break
+		}
+		assert.Equal(t, gqlwsmessage.Next, msg.Type)
+	}
+	conn.Ping(nil)
+	conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+}
+
+func TestSocketOperations(t *testing.T) {
+	redact := func(vars map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"token": `redacted`}
+	}
+	sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), RedactVariables: redact})
+	assert.Empty(t, sock.Operations())
+	query := `subscription Ticks{s}`
+	id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: query, OperationName: `Ticks`, Variables: map[string]interface{}{"token": `secret`}})
+	conn.ExpectNext(id)
+	conn.ExpectNext(id)
+	// the count may lag behind the messages received
+	assert.Eventually(t, func() bool {
+		ops := sock.Operations()
+		return len(ops) == 1 && ops[0].Sent >= 2
+	}, time.Second, time.Millisecond)
+	ops := sock.Operations()
+	if assert.Len(t, ops, 1) {
+		op := ops[0]
+		assert.Equal(t, id, op.ID)
+		assert.Equal(t, `Ticks`, op.Name)
+		assert.Equal(t, `subscription`, op.Type)
+		assert.Equal(t, gqlwsserver.DocumentID(query), op.QueryHash)
+		assert.Equal(t, map[string]interface{}{"token": `redacted`}, op.Variables)
+		assert.WithinDuration(t, time.Now(), op.StartedAt, time.Second)
+		assert.GreaterOrEqual(t, op.Sent, uint64(2))
+	}
+	conn.Complete(id)
+	assert.Eventually(t, func() bool { return len(sock.Operations()) == 0 }, time.Second, time.Millisecond)
+	t.Run("reports the type of the selected operation", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		conn.ExpectNext(conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `query A{q} subscription B{s}`, OperationName: `B`}))
+		if ops := sock.Operations(); assert.Len(t, ops, 1) {
+			assert.Equal(t, `subscription`, ops[0].Type)
+		}
+	})
+	t.Run("redacts variables by default", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		conn.ExpectNext(conn.Subscribe(gqlwsmessage.SubscribePayload{Query: query, Variables: map[string]interface{}{"token": `secret`}}))
+		if ops := sock.Operations(); assert.Len(t, ops, 1) {
+			assert.Equal(t, map[string]interface{}{"token": `[redacted]`}, ops[0].Variables)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package gqlwsserver
+
+import (
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// acceptPatches confirms in the ack payload that results are sent as JSON patches if the client asked for them.
+// returns false if they are disabled or the ack payload cannot carry the confirmation
+func (sock *Socket) acceptPatches(params gqlwsmessage.Payload, ack *gqlwsmessage.Payload) bool {
+	if !sock.EnableJSONPatch {
+		return false
+	}
+	p, _ := params.(map[string]interface{})
+	if requested, _ := p[gqlwsmessage.JSONPatchKey].(bool); !requested {
+		return false
+	}
+	payload, ok := (*ack).(map[string]interface{})
+	if *ack != nil && !ok {
+		return false
+	}
+	ackPayload := map[string]interface{}{gqlwsmessage.JSONPatchKey: true}
+	for k, v := range payload {
+		ackPayload[k] = v
+	}
+	*ack = ackPayload
+	return true
+}
+
+// patch replaces the result of a next message by a JSON patch against prev, the previous result of its operation,
+// which it then updates. the first result is sent in full
+func (sock *Socket) patch(msg *gqlwsmessage.Message, prev *interface{}) *gqlwsmessage.Message {
+	res, ok := msg.Payload.(*graphql.Result)
+	if !ok {
+		return msg
+	}
+	data, err := sock.Codec.Marshal(res)
+	if err != nil {
+		return msg
+	}
+	var doc interface{}
+	if err := sock.Codec.Unmarshal(data, &doc); err != nil {
+		return msg
+	}
+	from := *prev
+	*prev = doc
+	if from == nil {
+		return msg
+	}
+	return &gqlwsmessage.Message{Type: msg.Type, ID: msg.ID, Payload: map[string]interface{}{gqlwsmessage.PatchKey: gqlwsmessage.Diff(from, doc)}}
+}
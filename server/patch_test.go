@@ -0,0 +1,94 @@
+package gqlwsserver_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPatch(t *testing.T) {
+	counter := graphql.NewObject(graphql.ObjectConfig{
+		Name: `Counter`,
+		Fields: graphql.Fields{
+			"n":     &graphql.Field{Type: graphql.Int},
+			"label": &graphql.Field{Type: graphql.String},
+		},
+	})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   `Query`,
+			Fields: graphql.Fields{"q": &graphql.Field{Type: graphql.String}},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Subscription`,
+			Fields: graphql.Fields{
+				"counter": &graphql.Field{
+					Type: counter,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return map[string]interface{}{"n": p.Source, "label": `counter`}, nil
+					},
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						c := make(chan interface{})
+						go func() {
+							defer close(c)
+							for i := 0; i < 3; i++ {
+								select {
+								case c <- i:
+								case <-p.Context.Done():
+									return
+								}
+							}
+						}()
+						return c, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	query := gqlwsmessage.SubscribePayload{Query: `subscription{counter{n label}}`}
+	t.Run("sends patches after the first result", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema, EnableJSONPatch: true})
+		ack := conn.Init(map[string]interface{}{gqlwsmessage.JSONPatchKey: true})
+		assert.Equal(t, true, ack.Payload.(map[string]interface{})[gqlwsmessage.JSONPatchKey])
+		id := conn.Subscribe(query)
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"counter": map[string]interface{}{"n": 0, "label": `counter`}}))
+		for i := 1; i < 3; i++ {
+			expected := map[string]interface{}{gqlwsmessage.PatchKey: []interface{}{
+				map[string]interface{}{"op": `replace`, "path": `/data/counter/n`, "value": float64(i)},
+			}}
+			conn.Expect(gqlwstest.OfType(gqlwsmessage.Next), gqlwstest.WithID(id), gqlwstest.WithPayload(func(payload gqlwsmessage.Payload) error {
+				if !assert.ObjectsAreEqual(expected, payload) {
+					return fmt.Errorf(`expected patch %v, got %v`, expected, payload)
+				}
+				return nil
+			}))
+		}
+		conn.ExpectComplete(id)
+	})
+	t.Run("sends full results unless asked", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema, EnableJSONPatch: true})
+		ack := conn.Init(nil)
+		assert.Nil(t, ack.Payload)
+		id := conn.Subscribe(query)
+		for i := 0; i < 3; i++ {
+			conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"counter": map[string]interface{}{"n": i, "label": `counter`}}))
+		}
+		conn.ExpectComplete(id)
+	})
+	t.Run("sends full results unless enabled", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema})
+		ack := conn.Init(map[string]interface{}{gqlwsmessage.JSONPatchKey: true})
+		assert.Nil(t, ack.Payload)
+		id := conn.Subscribe(query)
+		for i := 0; i < 3; i++ {
+			conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"counter": map[string]interface{}{"n": i, "label": `counter`}}))
+		}
+		conn.ExpectComplete(id)
+	})
+}
@@ -0,0 +1,87 @@
+package gqlwsserver
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// ErrSocketClosed is returned by the methods of a Socket that has closed
+var ErrSocketClosed = errors.New(`Socket closed`)
+
+// Ping sends a ping and waits for the client to answer it. returns the round-trip time, which also feeds RTT.
+// pongs carry no id, so they answer the pending pings in order. the protocol lets clients send pongs unsolicited
+// as a heartbeat, and such a pong answers the oldest pending ping too, shortening its round-trip time
+func (sock *Socket) Ping(ctx context.Context) (time.Duration, error) {
+	// the pong is awaited before the ping is queued, as the writer may send it and the client answer it at once
+	pong := make(chan interface{}, 1)
+	sock.pingLock.Lock()
+	sock.pings = append(sock.pings, pong)
+	sock.pingLock.Unlock()
+	start := time.Now()
+	select {
+	case sock.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Ping}:
+	case <-sock.done:
+		return 0, ErrSocketClosed
+	case <-ctx.Done():
+		// the ping was never sent, so no pong answers it
+		sock.unping(pong)
+		return 0, ctx.Err()
+	}
+	select {
+	case <-pong:
+		rtt := time.Since(start)
+		sock.recordRTT(rtt)
+		return rtt, nil
+	case <-sock.done:
+		return 0, ErrSocketClosed
+	case <-ctx.Done():
+		// the pong still answers this ping once it comes, so later ones are not mismatched
+		return 0, ctx.Err()
+	}
+}
+
+// RTT returns the smoothed round-trip time measured by Ping, or 0 before the first measurement
+func (sock *Socket) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&sock.rtt))
+}
+
+// recordRTT updates the smoothed round-trip time the way TCP does, giving every sample a weight of 1/8
+func (sock *Socket) recordRTT(sample time.Duration) {
+	for {
+		old := atomic.LoadInt64(&sock.rtt)
+		rtt := int64(sample)
+		if old != 0 {
+			rtt = old + (int64(sample)-old)/8
+		}
+		if atomic.CompareAndSwapInt64(&sock.rtt, old, rtt) {
+			return
+		}
+	}
+}
+
+// unping withdraws a pending ping that was never sent
+func (sock *Socket) unping(pong chan interface{}) {
+	sock.pingLock.Lock()
+	defer sock.pingLock.Unlock()
+	for i, p := range sock.pings {
+		if p == pong {
+			sock.pings = append(sock.pings[:i:i], sock.pings[i+1:]...)
+			return
+		}
+	}
+}
+
+// pong answers the oldest pending ping. pongs without any pending ping are ignored
+func (sock *Socket) pong() {
+	sock.pingLock.Lock()
+	defer sock.pingLock.Unlock()
+	if len(sock.pings) == 0 {
+		return
+	}
+	sock.pings[0] <- nil
+	sock.pings = sock.pings[1:]
+}
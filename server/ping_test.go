@@ -0,0 +1,92 @@
+package gqlwsserver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSocket is gqlwstest.Serve keeping the socket at hand
+func serveSocket(t *testing.T, cfg *gqlwsserver.Config) (*gqlwsserver.Socket, *gqlwstest.Conn) {
+	clientEnd, serverEnd := gqlwstransport.Pipe()
+	cfg.Transport = serverEnd
+	socket := make(chan *gqlwsserver.Socket)
+	go func() { socket <- gqlwsserver.NewSocket(cfg) }()
+	conn := gqlwstest.NewConn(t, clientEnd)
+	// NewSocket returns once the connection is initialised
+	conn.Init(nil)
+	return <-socket, conn
+}
+
+func TestSocketPing(t *testing.T) {
+	t.Run("measures round-trip time", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		assert.Zero(t, sock.RTT())
+		type result struct {
+			rtt time.Duration
+			err error
+		}
+		res := make(chan result)
+		for i := 0; i < 2; i++ {
+			go func() {
+				rtt, err := sock.Ping(context.Background())
+				res <- result{rtt, err}
+			}()
+			conn.Expect(gqlwstest.OfType(gqlwsmessage.Ping))
+			time.Sleep(time.Millisecond * 10)
+			conn.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Pong})
+			r := <-res
+			assert.Nil(t, r.err)
+			assert.GreaterOrEqual(t, r.rtt, time.Millisecond*10)
+		}
+		assert.GreaterOrEqual(t, sock.RTT(), time.Millisecond*10)
+	})
+	t.Run("gives up with the context", func(t *testing.T) {
+		sock, _ := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := sock.Ping(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+	t.Run("withdraws a ping that was never sent", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), WriteBatchSize: 1})
+		// the conn holds the first ping unread, the writer blocks on the second and the third fills its queue,
+		// so the fourth gives up before being sent
+		for i := 0; i < 4; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+			_, err := sock.Ping(ctx)
+			cancel()
+			assert.Equal(t, context.DeadlineExceeded, err)
+		}
+		for i := 0; i < 3; i++ {
+			conn.Expect(gqlwstest.OfType(gqlwsmessage.Ping))
+			conn.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Pong})
+		}
+		res := make(chan error)
+		go func() {
+			_, err := sock.Ping(context.Background())
+			res <- err
+		}()
+		conn.Expect(gqlwstest.OfType(gqlwsmessage.Ping))
+		conn.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Pong})
+		select {
+		case err := <-res:
+			assert.Nil(t, err)
+		case <-time.After(time.Second):
+			t.Fatal(`the pong answered a ping that was never sent`)
+		}
+	})
+	t.Run("fails once closed", func(t *testing.T) {
+		sock, conn := serveSocket(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		conn.Close()
+		sock.Wait()
+		_, err := sock.Ping(context.Background())
+		assert.Equal(t, gqlwsserver.ErrSocketClosed, err)
+	})
+}
@@ -0,0 +1,54 @@
+package gqlwsserver
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry keeps track of the initialised sockets of a server, e.g. for an AdminHandler.
+// shared by all the sockets of a server through Config.Registry
+type Registry struct {
+	lock    sync.RWMutex
+	sockets map[string]*Socket
+}
+
+func NewRegistry() *Registry {
+	var r Registry
+	r.sockets = make(map[string]*Socket)
+	return &r
+}
+
+// Sockets returns the registered sockets, oldest first
+func (r *Registry) Sockets() []*Socket {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	sockets := make([]*Socket, 0, len(r.sockets))
+	for _, sock := range r.sockets {
+		sockets = append(sockets, sock)
+	}
+	sort.Slice(sockets, func(i, j int) bool { return sockets[i].connectedAt.Before(sockets[j].connectedAt) })
+	return sockets
+}
+
+// Get returns the socket of id, or nil if it is not registered
+func (r *Registry) Get(id string) *Socket {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.sockets[id]
+}
+
+// add registers sock unless it has closed already
+func (r *Registry) add(sock *Socket) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if isClosed(sock.done) {
+		return
+	}
+	r.sockets[sock.id] = sock
+}
+
+func (r *Registry) remove(sock *Socket) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.sockets, sock.id)
+}
@@ -0,0 +1,232 @@
+package gqlwsserver
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+type SessionsConfig struct {
+	// ResumeWindow is how long the operations of a session keep running after its socket drops
+	ResumeWindow time.Duration
+	// ReplayBufferSize is the number of operation messages kept for a resuming client
+	ReplayBufferSize int
+	// AuthorizeResume decides whether a client initialising with params may take over the session created with owner.
+	// by default both must be equal once the session keys are left out. a refused client starts a new session
+	AuthorizeResume func(owner, params ConnectionParams) bool
+}
+
+var defaultSessionsConfig = SessionsConfig{
+	ResumeWindow:     time.Second * 30,
+	ReplayBufferSize: 128,
+}
+
+func (c *SessionsConfig) init() {
+	if c.ResumeWindow <= 0 {
+		c.ResumeWindow = defaultSessionsConfig.ResumeWindow
+	}
+	if c.ReplayBufferSize <= 0 {
+		c.ReplayBufferSize = defaultSessionsConfig.ReplayBufferSize
+	}
+	if c.AuthorizeResume == nil {
+		c.AuthorizeResume = sameIdentity
+	}
+}
+
+// sameIdentity compares the params of two connections without the keys negotiating the session and its features
+func sameIdentity(owner, params ConnectionParams) bool {
+	identity := func(params ConnectionParams) interface{} {
+		if params == nil {
+			return map[string]interface{}{}
+		}
+		p, ok := params.(map[string]interface{})
+		if !ok {
+			return params
+		}
+		id := make(map[string]interface{}, len(p))
+		for k, v := range p {
+			switch k {
+			case gqlwsmessage.SessionTokenKey, gqlwsmessage.LastSeqKey, gqlwsmessage.JSONPatchKey:
+			default:
+				id[k] = v
+			}
+		}
+		return id
+	}
+	return reflect.DeepEqual(identity(owner), identity(params))
+}
+
+// Sessions lets clients resume their operations on a new socket.
+// a token is issued in connection_ack, and a client presenting it in connection_init within the resume window
+// receives the messages it missed before the live ones
+type Sessions struct {
+	*SessionsConfig
+
+	lock     sync.Mutex
+	sessions map[string]*session
+}
+
+func NewSessions(cfg *SessionsConfig) *Sessions {
+	var s Sessions
+	cfg.init()
+	s.SessionsConfig = cfg
+	s.sessions = make(map[string]*session)
+	return &s
+}
+
+type session struct {
+	owner *Sessions
+	token string
+	// the params of the connection that created the session, as refreshed since. guarded by lock
+	params ConnectionParams
+	// the operations outliving the socket
+	sm *subMan
+	// closed once the session expires
+	done chan interface{}
+
+	lock   sync.Mutex
+	seq    uint64
+	buffer []*gqlwsmessage.Message
+	sock   *Socket
+	expiry *time.Timer
+	// taken before lock is released so that messages are sent in sequence without holding lock
+	sending sync.Mutex
+}
+
+func (s *Sessions) create(params ConnectionParams) *session {
+	var ss session
+	ss.owner = s
+	ss.token = uuid.NewString()
+	ss.params = params
+	ss.sm = newSubMan()
+	ss.done = make(chan interface{})
+	s.lock.Lock()
+	s.sessions[ss.token] = &ss
+	s.lock.Unlock()
+	return &ss
+}
+
+// resume returns the session of token if the client with params may take it over
+// and every message after lastSeq can be replayed
+func (s *Sessions) resume(token string, lastSeq uint64, params ConnectionParams) *session {
+	s.lock.Lock()
+	ss := s.sessions[token]
+	s.lock.Unlock()
+	if ss == nil || !s.AuthorizeResume(ss.owned(), params) {
+		return nil
+	}
+	ss.lock.Lock()
+	missed := lastSeq > ss.seq || (len(ss.buffer) > 0 && ss.buffer[0].Seq > lastSeq+1)
+	if !missed && ss.expiry != nil {
+		ss.expiry.Stop()
+		ss.expiry = nil
+	}
+	ss.lock.Unlock()
+	if missed {
+		s.expire(ss)
+		return nil
+	}
+	return ss
+}
+
+func (s *Sessions) expire(ss *session) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sessions[ss.token] != ss {
+		return
+	}
+	delete(s.sessions, ss.token)
+	ss.sm.clear()
+	close(ss.done)
+}
+
+// owned returns the params a resuming client is authorized against
+func (ss *session) owned() ConnectionParams {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.params
+}
+
+// refresh replaces the params of the session with the refreshed ones of sock unless another socket has taken over
+func (ss *session) refresh(sock *Socket, params ConnectionParams) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.sock == sock {
+		ss.params = params
+	}
+}
+
+// attach replays the messages after lastSeq to sock, which then receives the live ones
+func (ss *session) attach(sock *Socket, lastSeq uint64) {
+	ss.lock.Lock()
+	var missed []*gqlwsmessage.Message
+	for _, msg := range ss.buffer {
+		if msg.Seq > lastSeq {
+			missed = append(missed, msg)
+		}
+	}
+	ss.sock = sock
+	ss.sending.Lock()
+	ss.lock.Unlock()
+	defer ss.sending.Unlock()
+	for _, msg := range missed {
+		sock.send(msg)
+	}
+}
+
+// detach starts the resume window unless another socket has taken over.
+// a session closed on purpose rather than dropped expires at once
+func (ss *session) detach(sock *Socket, err error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.sock != sock {
+		return
+	}
+	ss.sock = nil
+	if !resumable(err) {
+		ss.owner.expire(ss)
+		return
+	}
+	ss.expiry = time.AfterFunc(ss.owner.ResumeWindow, func() { ss.owner.expire(ss) })
+}
+
+// resumable reports whether a socket ending with err dropped rather than being closed
+func resumable(err error) bool {
+	if err == errClosed {
+		return false
+	}
+	var ce *gqlwserror.CloseError
+	if errors.As(err, &ce) {
+		return ce.Code() != gqlwserror.CloseNormal && ce.Code() < 4000
+	}
+	return true
+}
+
+// deliver numbers msg and keeps it for replay before sending it to the attached socket
+func (ss *session) deliver(msg *gqlwsmessage.Message) bool {
+	select {
+	case <-ss.done:
+		return false
+	default:
+	}
+	ss.lock.Lock()
+	ss.seq++
+	msg.Seq = ss.seq
+	ss.buffer = append(ss.buffer, msg)
+	if len(ss.buffer) > ss.owner.ReplayBufferSize {
+		ss.buffer = ss.buffer[1:]
+	}
+	sock := ss.sock
+	ss.sending.Lock()
+	ss.lock.Unlock()
+	defer ss.sending.Unlock()
+	if sock != nil {
+		sock.send(msg)
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package gqlwsserver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessions(t *testing.T) {
+	events := make(chan interface{})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"q": &graphql.Field{
+					Type:    graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) { return `hi`, nil },
+				},
+			},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Subscription`,
+			Fields: graphql.Fields{
+				"s": &graphql.Field{
+					Type:      graphql.String,
+					Resolve:   func(p graphql.ResolveParams) (interface{}, error) { return p.Source, nil },
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) { return events, nil },
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	sessions := gqlwsserver.NewSessions(&gqlwsserver.SessionsConfig{})
+	// connect returns the client end too so that a drop can be simulated
+	connect := func() (*gqlwstest.Conn, gqlwstransport.Transport) {
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		go func() {
+			gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: &schema, Sessions: sessions}).Wait()
+		}()
+		return gqlwstest.NewConn(t, clientEnd), clientEnd
+	}
+	ackOf := func(msg *gqlwsmessage.Message) (string, bool) {
+		payload := msg.Payload.(map[string]interface{})
+		return payload[gqlwsmessage.SessionTokenKey].(string), payload[gqlwsmessage.ResumedKey].(bool)
+	}
+	t.Run("issues a token", func(t *testing.T) {
+		conn, _ := connect()
+		token, resumed := ackOf(conn.Init(nil))
+		assert.NotEmpty(t, token)
+		assert.False(t, resumed)
+	})
+	t.Run("replays missed messages", func(t *testing.T) {
+		conn, transport := connect()
+		token, _ := ackOf(conn.Init(nil))
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+		events <- `a`
+		msg := conn.Expect(gqlwstest.OfType(gqlwsmessage.Next), gqlwstest.WithID(id), gqlwstest.WithData(map[string]interface{}{`s`: `a`}))
+		assert.Equal(t, uint64(1), msg.Seq)
+		transport.Close(gqlwserror.CloseAbnormal, `dropped`)
+		events <- `b`
+		events <- `c`
+		conn, _ = connect()
+		_, resumed := ackOf(conn.Init(map[string]interface{}{gqlwsmessage.SessionTokenKey: token, gqlwsmessage.LastSeqKey: 1}))
+		assert.True(t, resumed)
+		for i, data := range []string{`b`, `c`} {
+			msg := conn.Expect(gqlwstest.OfType(gqlwsmessage.Next), gqlwstest.WithID(id), gqlwstest.WithData(map[string]interface{}{`s`: data}))
+			assert.Equal(t, uint64(i+2), msg.Seq)
+		}
+		events <- `d`
+		conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{`s`: `d`}))
+		conn.Complete(id)
+	})
+	t.Run("rejects unknown token", func(t *testing.T) {
+		conn, _ := connect()
+		token, resumed := ackOf(conn.Init(map[string]interface{}{gqlwsmessage.SessionTokenKey: `unknown`}))
+		assert.NotEqual(t, `unknown`, token)
+		assert.False(t, resumed)
+	})
+	t.Run("rejects sequence ahead of the session", func(t *testing.T) {
+		conn, transport := connect()
+		token, _ := ackOf(conn.Init(nil))
+		transport.Close(gqlwserror.CloseAbnormal, `dropped`)
+		conn, _ = connect()
+		_, resumed := ackOf(conn.Init(map[string]interface{}{gqlwsmessage.SessionTokenKey: token, gqlwsmessage.LastSeqKey: 5}))
+		assert.False(t, resumed)
+	})
+	t.Run("refuses a client with other params", func(t *testing.T) {
+		conn, transport := connect()
+		token, _ := ackOf(conn.Init(map[string]interface{}{`user`: `alice`}))
+		transport.Close(gqlwserror.CloseAbnormal, `dropped`)
+		conn, _ = connect()
+		_, resumed := ackOf(conn.Init(map[string]interface{}{`user`: `mallory`, gqlwsmessage.SessionTokenKey: token}))
+		assert.False(t, resumed)
+		conn, _ = connect()
+		_, resumed = ackOf(conn.Init(map[string]interface{}{`user`: `alice`, gqlwsmessage.SessionTokenKey: token}))
+		assert.True(t, resumed)
+	})
+	t.Run("ends with a normal close", func(t *testing.T) {
+		conn, _ := connect()
+		token, _ := ackOf(conn.Init(nil))
+		conn.Close()
+		conn, _ = connect()
+		_, resumed := ackOf(conn.Init(map[string]interface{}{gqlwsmessage.SessionTokenKey: token}))
+		assert.False(t, resumed)
+	})
+}
+
+func TestSessionsAuthorizeResume(t *testing.T) {
+	sessions := gqlwsserver.NewSessions(&gqlwsserver.SessionsConfig{AuthorizeResume: func(owner, params gqlwsserver.ConnectionParams) bool {
+		return params.(map[string]interface{})[`allowed`] == true
+	}})
+	connect := func() (*gqlwstest.Conn, gqlwstransport.Transport) {
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		go func() {
+			gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: gqlwstest.Schema(t), Sessions: sessions}).Wait()
+		}()
+		return gqlwstest.NewConn(t, clientEnd), clientEnd
+	}
+	conn, transport := connect()
+	token := conn.Init(nil).Payload.(map[string]interface{})[gqlwsmessage.SessionTokenKey]
+	transport.Close(gqlwserror.CloseAbnormal, `dropped`)
+	for _, allowed := range []bool{false, true} {
+		conn, _ = connect()
+		ack := conn.Init(map[string]interface{}{`allowed`: allowed, gqlwsmessage.SessionTokenKey: token})
+		assert.Equal(t, allowed, ack.Payload.(map[string]interface{})[gqlwsmessage.ResumedKey], allowed)
+	}
+}
+
+func TestSessionsRefresh(t *testing.T) {
+	sessions := gqlwsserver.NewSessions(&gqlwsserver.SessionsConfig{})
+	connect := func() (*gqlwstest.Conn, gqlwstransport.Transport) {
+		clientEnd, serverEnd := gqlwstransport.Pipe()
+		go func() {
+			gqlwsserver.NewSocket(&gqlwsserver.Config{Transport: serverEnd, Schema: gqlwstest.Schema(t), Sessions: sessions,
+				OnRefresh: func(m *gqlwsmessage.Message) (time.Time, error) { return time.Now().Add(time.Hour), nil },
+			}).Wait()
+		}()
+		return gqlwstest.NewConn(t, clientEnd), clientEnd
+	}
+	conn, transport := connect()
+	token := conn.Init(map[string]interface{}{`token`: `old`}).Payload.(map[string]interface{})[gqlwsmessage.SessionTokenKey]
+	conn.Ping(map[string]interface{}{gqlwsmessage.RefreshKey: map[string]interface{}{`token`: `new`}})
+	conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+	transport.Close(gqlwserror.CloseAbnormal, `dropped`)
+	for _, credentials := range []string{`old`, `new`} {
+		conn, _ = connect()
+		ack := conn.Init(map[string]interface{}{`token`: credentials, gqlwsmessage.SessionTokenKey: token})
+		assert.Equal(t, credentials == `new`, ack.Payload.(map[string]interface{})[gqlwsmessage.ResumedKey], credentials)
+	}
+}
@@ -1,33 +1,111 @@
 package gqlwsserver
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
 
 type subMan struct {
-	subs map[string]chan interface{}
+	subs map[string]*operation
 	lock sync.RWMutex
+	// when the last subscription ended
+	idleSince time.Time
+	// the messages ending the subscriptions stopped by the server, sent once their results are flushed
+	ends map[string]*gqlwsmessage.Message
+	// set once cleared. operations added later are stopped from the start
+	cleared bool
+}
+
+// operation is a running subscription. stop is closed once it is stopped
+type operation struct {
+	// the next messages sent. first to be aligned for atomic access
+	sent uint64
+	// set before stop is closed by the server, which flushes the queued results rather than discarding them
+	flush     int32
+	stop      chan interface{}
+	query     *gqlwsmessage.SubscribePayload
+	startedAt time.Time
+	// held while a result is delivered, so that a complete waits for the one in flight
+	sending sync.Mutex
+}
+
+// discards reports whether the queued results are dropped, as the client completed the operation
+func (op *operation) discards() bool {
+	return isClosed(op.stop) && atomic.LoadInt32(&op.flush) == 0
 }
 
 func newSubMan() *subMan {
 	var sm subMan
-	sm.subs = make(map[string]chan interface{})
+	sm.subs = make(map[string]*operation)
+	sm.ends = make(map[string]*gqlwsmessage.Message)
+	sm.idleSince = time.Now()
 	return &sm
 }
 
-func (sm *subMan) add(id string) chan interface{} {
+// add registers id running q unless it is already running
+func (sm *subMan) add(id string, q *gqlwsmessage.SubscribePayload) (*operation, bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if _, ok := sm.subs[id]; ok {
+		return nil, false
+	}
+	if sm.cleared {
+		op := &operation{stop: make(chan interface{}), query: q, startedAt: time.Now()}
+		close(op.stop)
+		return op, true
+	}
+	sm.subs[id] = &operation{stop: make(chan interface{}), query: q, startedAt: time.Now()}
+	return sm.subs[id], true
+}
+
+// del stops id. returns the operation stopped, or nil if id is not running
+func (sm *subMan) del(id string) *operation {
 	sm.lock.Lock()
 	defer sm.lock.Unlock()
-	sm.subs[id] = make(chan interface{})
-	return sm.subs[id]
+	op := sm.subs[id]
+	sm.remove(id)
+	return op
 }
 
-func (sm *subMan) del(id string) {
+// end stops id like del, leaving msg to be sent in place of its completion. returns false if id is not running
+func (sm *subMan) end(id string, msg *gqlwsmessage.Message) bool {
 	sm.lock.Lock()
 	defer sm.lock.Unlock()
+	if op := sm.subs[id]; op != nil {
+		atomic.StoreInt32(&op.flush, 1)
+	}
+	if !sm.remove(id) {
+		return false
+	}
+	sm.ends[id] = msg
+	return true
+}
+
+// finish stops id once it has run to its end, unless it was stopped meanwhile. returns the message left by end if the
+// server stopped it, and whether it was still running, so that the operation is ended in a single way
+func (sm *subMan) finish(id string) (*gqlwsmessage.Message, bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	running := sm.remove(id)
+	msg := sm.ends[id]
+	delete(sm.ends, id)
+	return msg, running
+}
+
+func (sm *subMan) remove(id string) bool {
 	sub := sm.subs[id]
-	if sub != nil {
-		close(sub)
-		delete(sm.subs, id)
+	if sub == nil {
+		return false
+	}
+	close(sub.stop)
+	delete(sm.subs, id)
+	if len(sm.subs) == 0 {
+		sm.idleSince = time.Now()
 	}
+	return true
 }
 
 func (sm *subMan) has(id string) bool {
@@ -36,3 +114,32 @@ func (sm *subMan) has(id string) bool {
 	_, ok := sm.subs[id]
 	return ok
 }
+
+// list returns the running subscriptions by id
+func (sm *subMan) list() map[string]*operation {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	ops := make(map[string]*operation, len(sm.subs))
+	for id, sub := range sm.subs {
+		ops[id] = sub
+	}
+	return ops
+}
+
+// idle returns when the last subscription ended, or false if some are running
+func (sm *subMan) idle() (time.Time, bool) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return sm.idleSince, len(sm.subs) == 0
+}
+
+// clear stops every subscription, and those added afterwards
+func (sm *subMan) clear() {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.cleared = true
+	for id, sub := range sm.subs {
+		close(sub.stop)
+		delete(sm.subs, id)
+	}
+}
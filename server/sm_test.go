@@ -0,0 +1,40 @@
+package gqlwsserver
+
+import (
+	"testing"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubManFinish(t *testing.T) {
+	id := `id`
+	end := &gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: &id}
+	t.Run("finishes a running operation", func(t *testing.T) {
+		sm := newSubMan()
+		sm.add(id, &gqlwsmessage.SubscribePayload{})
+		msg, running := sm.finish(id)
+		assert.Nil(t, msg)
+		assert.True(t, running)
+		// an end racing the finish finds nothing left to stop
+		assert.False(t, sm.end(id, end))
+		assert.Empty(t, sm.ends)
+	})
+	t.Run("returns the end left by the server", func(t *testing.T) {
+		sm := newSubMan()
+		sm.add(id, &gqlwsmessage.SubscribePayload{})
+		assert.True(t, sm.end(id, end))
+		msg, running := sm.finish(id)
+		assert.Equal(t, end, msg)
+		assert.False(t, running)
+		assert.Empty(t, sm.ends)
+	})
+	t.Run("reports an operation completed by the client", func(t *testing.T) {
+		sm := newSubMan()
+		sm.add(id, &gqlwsmessage.SubscribePayload{})
+		assert.NotNil(t, sm.del(id))
+		msg, running := sm.finish(id)
+		assert.Nil(t, msg)
+		assert.False(t, running)
+	})
+}
@@ -1,98 +1,192 @@
 package gqlwsserver
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
-	"github.com/graphql-go/graphql/language/ast"
 	goutils "github.com/onichandame/go-utils"
 	gqlwserror "github.com/onichandame/gql-ws/error"
 	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
 )
 
 type Socket struct {
 	*Config
 
+	// identifies the socket in the Registry
+	id          string
+	connectedAt time.Time
+
 	reader, writer chan *gqlwsmessage.Message
 	breaker        chan error
 	done           chan interface{}
 	init           chan *gqlwsmessage.Message
-	inited         bool
-	err            error
-	// the connection parameters negotiated on ConnectionInit
+	initRequested  int32
+	inited         int32
+	// closed once the connection init has been handled or abandoned
+	initialised chan interface{}
+	err         error
+	// the connection parameters negotiated on ConnectionInit and updated by refreshes
 	// will inject into every graphql resolver. can be retrieved by context.Value(reflect.Typeof(ConnectionParams{}))
+	paramsLock       sync.Mutex
 	connectionParams ConnectionParams
 
+	transport gqlwstransport.Transport
+	// set when frames can be batched on the hijacked connection
+	bc *batchConn
+
 	sm *subMan
+	// set once the socket has joined a session, which then owns the operations
+	session *session
+	// whether results are sent as JSON patches
+	patches bool
+	// results discarded by the backpressure policy
+	dropped uint64
+	// fires once the credentials expire
+	expiryLock sync.Mutex
+	expiry     *time.Timer
+	// the pings sent by Ping awaiting their pong, and the smoothed round-trip time in nanoseconds
+	pingLock sync.Mutex
+	pings    []chan interface{}
+	rtt      int64
+	// the messages read and written
+	received, sent uint64
 }
 
 func NewSocket(cfg *Config) *Socket {
 	var sock Socket
+	if cfg.Transport == nil && (cfg.Response == nil || cfg.Request == nil) {
+		panic(errors.New(`gql-ws socket received invalid parameters`))
+	}
 	cfg.init()
 	sock.Config = cfg
+	sock.id = uuid.NewString()
+	sock.connectedAt = time.Now()
 	sock.reader = make(chan *gqlwsmessage.Message)
-	sock.writer = make(chan *gqlwsmessage.Message)
+	sock.writer = make(chan *gqlwsmessage.Message, cfg.WriteBatchSize)
 	sock.init = make(chan *gqlwsmessage.Message)
 	sock.breaker = make(chan error)
 	sock.done = make(chan interface{})
+	sock.initialised = make(chan interface{})
 	sock.sm = newSubMan()
 	sock.listen()
 	return &sock
 }
 
+var errClosed = errors.New(`closed by user`)
+
 func (sock *Socket) Close() {
-	defer goutils.RecoverToErr(new(error))
-	sock.breaker <- errors.New(`closed by user`)
+	sock.fail(errClosed)
 }
 func (sock *Socket) Wait() {
 	<-sock.done
 }
 func (sock *Socket) Error() error { return sock.err }
 
+// Dropped returns the number of results discarded by the backpressure policy
+func (sock *Socket) Dropped() uint64 { return atomic.LoadUint64(&sock.dropped) }
+
+// ID identifies the socket in the Registry
+func (sock *Socket) ID() string { return sock.id }
+
+// ConnectedAt returns when the socket was opened
+func (sock *Socket) ConnectedAt() time.Time { return sock.connectedAt }
+
+// RemoteAddr returns the address of the client, or an empty string if the socket runs on a custom Transport
+func (sock *Socket) RemoteAddr() string {
+	if sock.Request == nil {
+		return ``
+	}
+	return sock.Request.RemoteAddr
+}
+
+// ConnectionParams returns the payload of the connection_init message, with the credentials of the accepted refreshes
+func (sock *Socket) ConnectionParams() ConnectionParams {
+	sock.paramsLock.Lock()
+	defer sock.paramsLock.Unlock()
+	return sock.connectionParams
+}
+
+func (sock *Socket) setConnectionParams(params ConnectionParams) {
+	sock.paramsLock.Lock()
+	defer sock.paramsLock.Unlock()
+	sock.connectionParams = params
+}
+
+// Received returns the number of messages read from the client
+func (sock *Socket) Received() uint64 { return atomic.LoadUint64(&sock.received) }
+
+// Sent returns the number of messages written to the client
+func (sock *Socket) Sent() uint64 { return atomic.LoadUint64(&sock.sent) }
+
 func (sock *Socket) listen() {
-	conn := sock.getConn()
+	sock.transport, sock.bc = sock.getTransport()
 
 	// cleanup
 	go func() {
+		defer func() {
+			// the session is joined by the init
+			<-sock.initialised
+			if sock.session != nil {
+				sock.session.detach(sock, sock.err)
+			}
+			if sock.Registry != nil {
+				sock.Registry.remove(sock)
+			}
+		}()
 		defer close(sock.done)
-		defer conn.Close()
 		err := <-sock.breaker
 		sock.err = err
-		if err != nil {
-			if err := conn.WriteControl(websocket.CloseMessage, []byte(err.Error()), time.Now().Add(sock.GraceClosePeriod)); err == nil {
-				time.Sleep(sock.GraceClosePeriod)
-			}
+		sock.expireAt(time.Time{})
+		sock.sm.clear()
+		if sock.bc != nil {
+			sock.bc.disable()
 		}
+		sock.transport.Close(closeCode(err))
 	}()
 	// reader
 	go func() {
 		var err error
-		defer func() {
-			defer goutils.RecoverToErr(new(error))
-			sock.breaker <- err
-		}()
+		defer func() { sock.fail(err) }()
 		defer goutils.RecoverToErr(&err)
 		for {
-			var msg gqlwsmessage.Message
-			goutils.Assert(conn.ReadJSON(&msg))
-			sock.reader <- &msg
+			msg, err := sock.transport.ReadMessage()
+			goutils.Assert(err)
+			atomic.AddUint64(&sock.received, 1)
+			select {
+			case sock.reader <- msg:
+			case <-sock.done:
+				return
+			}
 		}
 	}()
 	// writer
 	go func() {
 		var err error
-		defer func() {
-			defer goutils.RecoverToErr(new(error))
-			sock.breaker <- err
-		}()
+		defer func() { sock.fail(err) }()
 		defer goutils.RecoverToErr(&err)
-		for msg := range sock.writer {
-			goutils.Assert(conn.WriteJSON(msg))
+		for {
+			var msg *gqlwsmessage.Message
+			select {
+			case msg = <-sock.writer:
+			case <-sock.done:
+				return
+			}
+			if sock.bc == nil {
+				sock.write(msg)
+				continue
+			}
+			sock.bc.hold()
+			sock.write(msg)
+			sock.batch()
+			goutils.Assert(sock.bc.flush())
 		}
 	}()
 	// listener
@@ -100,6 +194,11 @@ func (sock *Socket) listen() {
 		for {
 			select {
 			case req := <-sock.reader:
+				if req.Type == gqlwsmessage.Complete {
+					// nothing of the operation is sent after the answers to the messages following its complete
+					sock.handleRequest(req)
+					continue
+				}
 				go sock.handleRequest(req)
 			case <-sock.done:
 				return
@@ -109,123 +208,381 @@ func (sock *Socket) listen() {
 	// init
 	func() {
 		var err error
+		defer close(sock.initialised)
 		defer func() {
-			defer goutils.RecoverToErr(new(error))
 			if err != nil {
-				sock.breaker <- err
+				sock.fail(err)
 			}
 		}()
 		defer goutils.RecoverToErr(&err)
-		sock.inited = false
-		timeout := make(chan int)
-		go func() {
-			defer goutils.RecoverToErr(new(error))
-			time.Sleep(sock.ConnectionInitTimeout)
-			if !sock.inited {
-				timeout <- 0
-			}
-		}()
+		timeout := time.NewTimer(sock.ConnectionInitTimeout)
+		defer timeout.Stop()
 		select {
-		case <-timeout:
-			panic(gqlwserror.NewFatalError(4408, `Connection initialisation timeout`))
+		case <-timeout.C:
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseInitTimeout, `Connection initialisation timeout`))
+		case <-sock.done:
 		case init := <-sock.init:
-			sock.OnConnectionInit(init)
-			sock.connectionParams = init.Payload
-			sock.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.ConnectionAck, Payload: sock.OnConnectionInit(init)}
-			sock.inited = true
+			sock.decodePayload(init)
+			sock.setConnectionParams(init.Payload)
+			payload, expiry := unwrapExpiry(sock.OnConnectionInit(init))
+			session, lastSeq := sock.joinSession(init.Payload, &payload)
+			sock.session = session
+			sock.patches = sock.acceptPatches(init.Payload, &payload)
+			atomic.StoreInt32(&sock.inited, 1)
+			sock.send(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionAck, Payload: payload})
+			if session != nil {
+				session.attach(sock, lastSeq)
+			}
+			sock.expireAt(expiry)
+			if sock.Registry != nil {
+				sock.Registry.add(sock)
+			}
+			go sock.watchIdle()
 		}
 	}()
 }
-func (sock *Socket) getConn() *websocket.Conn {
+
+// watchIdle closes the socket once it has had no operations for IdleTimeout
+func (sock *Socket) watchIdle() {
+	if sock.IdleTimeout <= 0 {
+		return
+	}
+	timer := time.NewTimer(sock.IdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-sock.done:
+			return
+		}
+		ops, _ := sock.operations()
+		wait := sock.IdleTimeout
+		if since, idle := ops.idle(); idle {
+			wait -= time.Since(since)
+			if wait <= 0 {
+				sock.fail(gqlwserror.NewCloseError(gqlwserror.CloseIdleTimeout, `Idle timeout`))
+				return
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// batch writes the queued messages following the current one until the batch is full or the flush latency elapses
+func (sock *Socket) batch() {
+	var deadline <-chan time.Time
+	if sock.WriteFlushLatency > 0 {
+		timer := time.NewTimer(sock.WriteFlushLatency)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for i := 1; i < sock.WriteBatchSize; i++ {
+		var msg *gqlwsmessage.Message
+		var ok bool
+		if deadline == nil {
+			select {
+			case msg, ok = <-sock.writer:
+			default:
+				return
+			}
+		} else {
+			select {
+			case msg, ok = <-sock.writer:
+			case <-deadline:
+				return
+			}
+		}
+		if !ok {
+			return
+		}
+		sock.write(msg)
+	}
+}
+
+func (sock *Socket) write(msg *gqlwsmessage.Message) {
+	goutils.Assert(sock.transport.WriteMessage(msg))
+	atomic.AddUint64(&sock.sent, 1)
+}
+
+// getTransport returns the configured transport or upgrades the request to a websocket
+func (sock *Socket) getTransport() (gqlwstransport.Transport, *batchConn) {
+	if sock.Transport != nil {
+		return sock.Transport, nil
+	}
+	var subprotocols []string
+	for _, enc := range sock.Encodings {
+		subprotocols = append(subprotocols, enc.Subprotocol())
+	}
+	subprotocols = append(subprotocols, gqlwsmessage.Subprotocol)
 	upgrader := websocket.Upgrader{
-		ReadBufferSize:   1024,
-		WriteBufferSize:  1024,
-		CheckOrigin:      func(r *http.Request) bool { return true },
-		HandshakeTimeout: time.Second * 5,
-		Subprotocols:     []string{`graphql-transport-ws`}, // internalize
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		CheckOrigin:       func(r *http.Request) bool { return true },
+		HandshakeTimeout:  time.Second * 5,
+		Subprotocols:      subprotocols,
+		EnableCompression: sock.EnableCompression,
 	}
-	conn, err := upgrader.Upgrade(sock.Response, sock.Request, nil)
+	w := &batchResponseWriter{ResponseWriter: sock.Response}
+	conn, err := upgrader.Upgrade(w, sock.Request, nil)
 	goutils.Assert(err)
-	if conn.Subprotocol() != `graphql-transport-ws` {
+	encoding := sock.getEncoding(conn.Subprotocol())
+	if encoding == nil {
+		conn.Close()
 		panic(errors.New(`subprotocol must be graphql-transport-ws`))
 	}
-	return conn
+	if sock.EnableCompression && sock.CompressionLevel != 0 {
+		goutils.Assert(conn.SetCompressionLevel(sock.CompressionLevel))
+	}
+	return gqlwstransport.NewWebSocket(conn, &gqlwstransport.WebSocketConfig{
+		Encoding:             encoding,
+		EnableCompression:    sock.EnableCompression,
+		CompressionThreshold: sock.CompressionThreshold,
+		GraceClosePeriod:     sock.GraceClosePeriod,
+	}), w.conn
+}
+func (sock *Socket) getEncoding(subprotocol string) gqlwsmessage.Encoding {
+	for _, enc := range sock.Encodings {
+		if enc.Subprotocol() == subprotocol {
+			return enc
+		}
+	}
+	if subprotocol == gqlwsmessage.Subprotocol {
+		return gqlwsmessage.NewJSONEncoding(sock.Codec)
+	}
+	return nil
+}
+
+// closeCode returns the close code and reason the connection ends with after err
+func closeCode(err error) (int, string) {
+	if err == errClosed {
+		return gqlwserror.CloseNormal, err.Error()
+	}
+	ce := gqlwserror.ToCloseError(err)
+	return ce.Code(), ce.Reason()
+}
+
+// decodePayload replaces the raw payload of msg with its generic form before it is handed to the hooks
+func (sock *Socket) decodePayload(msg *gqlwsmessage.Message) {
+	if msg.Payload == nil {
+		return
+	}
+	var payload gqlwsmessage.Payload
+	if err := sock.transport.Encoding().DecodePayload(msg.Payload, &payload); err != nil {
+		panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `Invalid message payload`))
+	}
+	msg.Payload = payload
 }
 func (sock *Socket) handleRequest(msg *gqlwsmessage.Message) {
 	var err error
 	defer func() {
-		defer goutils.RecoverToErr(new(error))
 		if err != nil {
 			if he, ok := err.(*gqlwserror.HandlableError); ok {
-				sock.writer <- he.GetMessage()
+				sock.deliver(he.GetMessage())
 			} else {
-				sock.breaker <- err
+				sock.fail(err)
 			}
 		}
 	}()
 	defer goutils.RecoverToErr(&err)
 	switch msg.Type {
 	case gqlwsmessage.ConnectionInit:
-		sock.init <- msg
+		if !atomic.CompareAndSwapInt32(&sock.initRequested, 0, 1) {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseTooManyInitRequests, `Too many initialisation requests`))
+		}
+		select {
+		case sock.init <- msg:
+		case <-sock.done:
+		}
 	case gqlwsmessage.Ping:
+		sock.decodePayload(msg)
+		if atomic.LoadInt32(&sock.inited) == 1 {
+			sock.refresh(msg)
+		}
 		var payload gqlwsmessage.Payload
 		if sock.OnPing != nil {
 			payload = sock.OnPing(msg)
 		}
-		sock.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Pong, Payload: payload}
+		sock.send(&gqlwsmessage.Message{Type: gqlwsmessage.Pong, Payload: payload})
 	case gqlwsmessage.Pong:
+		sock.pong()
 		if sock.OnPong != nil {
+			sock.decodePayload(msg)
 			sock.OnPong(msg)
 		}
 	case gqlwsmessage.Subscribe:
-		if !sock.inited {
-			panic(gqlwserror.NewFatalError(4401, `Unauthorized`))
+		if atomic.LoadInt32(&sock.inited) == 0 {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseUnauthorized, `Unauthorized`))
 		}
 		if msg.ID == nil {
-			panic(gqlwserror.NewFatalError(4400, `Subscriber must come with an id`))
-		}
-		if sock.sm.has(*msg.ID) {
-			panic(gqlwserror.NewFatalError(4409, fmt.Sprintf(`Subscriber for %v already exists`, *msg.ID)))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `Subscriber must come with an id`))
 		}
 		var query gqlwsmessage.SubscribePayload
-		if err := goutils.Try(func() { goutils.UnmarshalJSONFromMap(msg.Payload.(map[string]interface{}), &query) }); err != nil {
-			panic(gqlwserror.NewFatalError(4400, `Payload of subscribe request invalid`))
-		}
-		stopchan := sock.sm.add(*msg.ID)
-		defer sock.sm.del(*msg.ID)
-		if getOperationTypeOfReq(query.Query) == ast.OperationTypeSubscription {
-			params := sock.getGqlParams(&query, stopchan)
-			reschan := graphql.Subscribe(*params)
-			for {
-				res, ok := <-reschan
-				if !ok {
-					return
-				}
-				sock.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Next, Payload: res, ID: msg.ID}
+		if err := sock.transport.Encoding().DecodePayload(msg.Payload, &query); err != nil {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `Payload of subscribe request invalid`))
+		}
+		if err := sock.resolveDocument(&query); err != nil {
+			panic(gqlwserror.NewHandlableError(*msg.ID, err.Error()))
+		}
+		if query.Query == `` {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `Payload of subscribe request invalid`))
+		}
+		ops, done := sock.operations()
+		op, ok := ops.add(*msg.ID, &query)
+		if !ok {
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseSubscriberExists, fmt.Sprintf(`Subscriber for %v already exists`, *msg.ID)))
+		}
+		defer ops.del(*msg.ID)
+		ob := newOutbox(*msg.ID, sock.Config, done, sock.drop)
+		pumped := make(chan interface{})
+		go sock.pump(ob, op, pumped)
+		defer ob.close()
+		stopchan := op.stop
+		err := sock.execute(sock.ConnectionParams(), &query, stopchan, func(res *graphql.Result) error {
+			if isClosed(stopchan) {
+				// the client is no longer listening
+				return nil
 			}
-		} else {
-			sock.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Next, ID: msg.ID, Payload: graphql.Do(*sock.getGqlParams(&query, stopchan))}
+			// a resolver failing with a CloseError closes the socket with it
+			if ce := gqlwserror.FromGraphQLErrors(res.Errors); ce != nil {
+				return ce
+			}
+			return ob.push(&gqlwsmessage.Message{Type: gqlwsmessage.Next, Payload: res, ID: msg.ID})
+		})
+		if err != errOperationTimeout {
+			goutils.Assert(err)
+		}
+		// flush the queued results before completing. those of an operation completed by the client are discarded
+		ob.close()
+		<-pumped
+		// the server may have ended the operation, otherwise the client may have completed it itself
+		if end, running := ops.finish(*msg.ID); end != nil {
+			sock.deliver(end)
+			return
+		} else if !running {
+			return
+		}
+		if err != nil {
+			panic(gqlwserror.NewHandlableError(*msg.ID, err.Error()))
 		}
-		sock.writer <- &gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: msg.ID}
+		sock.deliver(&gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: msg.ID})
 	case gqlwsmessage.Complete:
 		if msg.ID == nil {
-			panic(gqlwserror.NewFatalError(4400, `complete message must come with an id`))
+			panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `complete message must come with an id`))
+		}
+		ops, _ := sock.operations()
+		if op := ops.del(*msg.ID); op != nil {
+			// wait for the result being delivered, the following ones are discarded
+			op.sending.Lock()
+			op.sending.Unlock()
 		}
-		sock.sm.del(*msg.ID)
 	default:
-		panic(gqlwserror.NewFatalError(4400, fmt.Sprintf(`message type %v not supported`, msg.Type)))
+		panic(gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, fmt.Sprintf(`message type %v not supported`, msg.Type)))
+	}
+}
+
+// fail ends the socket with err unless it has already ended
+func (sock *Socket) fail(err error) {
+	select {
+	case sock.breaker <- err:
+	case <-sock.done:
+	}
+}
+
+// send queues msg for the writer unless the socket is closed
+func (sock *Socket) send(msg *gqlwsmessage.Message) {
+	select {
+	case sock.writer <- msg:
+	case <-sock.done:
 	}
 }
-func (sock *Socket) getGqlParams(q *gqlwsmessage.SubscribePayload, stopchan chan interface{}) *graphql.Params {
-	ctx := sock.Context
-	if ctx == nil {
-		ctx = context.Background()
+
+// pump forwards the results queued in ob for op to the writer
+func (sock *Socket) pump(ob *outbox, op *operation, pumped chan interface{}) {
+	defer close(pumped)
+	var prev interface{}
+	for {
+		msg, ok := ob.pop()
+		if !ok {
+			return
+		}
+		op.sending.Lock()
+		if op.discards() {
+			op.sending.Unlock()
+			continue
+		}
+		if sock.patches {
+			msg = sock.patch(msg, &prev)
+		}
+		delivered := sock.deliver(msg)
+		op.sending.Unlock()
+		if !delivered {
+			return
+		}
+		atomic.AddUint64(&op.sent, 1)
+	}
+}
+
+// deliver sends an operation message through the session if there is one.
+// returns false once the operation can no longer be delivered
+func (sock *Socket) deliver(msg *gqlwsmessage.Message) bool {
+	if session := sock.joined(); session != nil {
+		return session.deliver(msg)
 	}
-	return &graphql.Params{
-		Schema:         *sock.Schema,
-		RequestString:  q.Query,
-		VariableValues: q.Variables,
-		OperationName:  q.OperationName,
-		Context:        context.WithValue(context.WithValue(ctx, connParamsKey, sock.connectionParams), subscriptionStopKey, stopchan),
+	select {
+	case sock.writer <- msg:
+		return true
+	case <-sock.done:
+		return false
 	}
 }
+
+// operations returns the running operations and the channel closed once they are abandoned
+func (sock *Socket) operations() (*subMan, chan interface{}) {
+	if session := sock.joined(); session != nil {
+		return session.sm, session.done
+	}
+	return sock.sm, sock.done
+}
+
+// joined returns the session the socket joined on init, if any
+func (sock *Socket) joined() *session {
+	if atomic.LoadInt32(&sock.inited) == 0 {
+		return nil
+	}
+	return sock.session
+}
+
+// joinSession resumes the session presented in the init payload or starts a new one, announcing it in the ack payload.
+// returns nil if sessions are disabled or the ack payload cannot carry the token
+func (sock *Socket) joinSession(params gqlwsmessage.Payload, ack *gqlwsmessage.Payload) (*session, uint64) {
+	if sock.Sessions == nil {
+		return nil, 0
+	}
+	payload, ok := (*ack).(map[string]interface{})
+	if *ack != nil && !ok {
+		return nil, 0
+	}
+	var s *session
+	var lastSeq uint64
+	if p, ok := params.(map[string]interface{}); ok {
+		if token, ok := p[gqlwsmessage.SessionTokenKey].(string); ok {
+			lastSeq = toUint64(p[gqlwsmessage.LastSeqKey])
+			s = sock.Sessions.resume(token, lastSeq, params)
+		}
+	}
+	resumed := s != nil
+	if !resumed {
+		s = sock.Sessions.create(params)
+		lastSeq = 0
+	}
+	ackPayload := map[string]interface{}{gqlwsmessage.SessionTokenKey: s.token, gqlwsmessage.ResumedKey: resumed}
+	for k, v := range payload {
+		ackPayload[k] = v
+	}
+	*ack = ackPayload
+	return s, lastSeq
+}
+func (sock *Socket) drop(id string, msg *gqlwsmessage.Message) {
+	atomic.AddUint64(&sock.dropped, 1)
+	sock.OnDrop(id, msg)
+}
@@ -1,9 +1,15 @@
 package gqlwsserver_test
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,8 +18,10 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
 	goutils "github.com/onichandame/go-utils"
+	gqlwserror "github.com/onichandame/gql-ws/error"
 	gqlwsmessage "github.com/onichandame/gql-ws/message"
 	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -152,3 +160,245 @@ func TestSocket(t *testing.T) {
 		}
 	})
 }
+
+func BenchmarkSocketWrite(b *testing.B) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   `Query`,
+			Fields: graphql.Fields{"q": &graphql.Field{Type: graphql.String}},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Subscription`,
+			Fields: graphql.Fields{
+				"s": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						c := make(chan interface{})
+						stop := gqlwsserver.GetSubscriptionStopSig(p.Context)
+						go func() {
+							defer close(c)
+							for {
+								select {
+								case <-p.Context.Done():
+									return
+								case <-stop:
+									return
+								case c <- `hi`:
+								}
+							}
+						}()
+						return c, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(b, err)
+	for _, size := range []int{1, 32} {
+		b.Run(fmt.Sprintf("batch %v", size), func(b *testing.B) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sock := gqlwsserver.NewSocket(&gqlwsserver.Config{Response: w, Request: r, Schema: &schema, WriteBatchSize: size})
+				sock.Wait()
+			}))
+			defer server.Close()
+			uri, err := url.Parse(server.URL)
+			assert.Nil(b, err)
+			uri.Scheme = `ws`
+			conn, _, err := websocket.DefaultDialer.Dial(uri.String(), http.Header{"Sec-WebSocket-Protocol": []string{`graphql-transport-ws`}})
+			assert.Nil(b, err)
+			defer conn.Close()
+			assert.Nil(b, conn.WriteJSON(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit}))
+			var msg gqlwsmessage.Message
+			assert.Nil(b, conn.ReadJSON(&msg))
+			id := uuid.NewString()
+			b.ResetTimer()
+			assert.Nil(b, conn.WriteJSON(&gqlwsmessage.Message{Type: gqlwsmessage.Subscribe, ID: &id, Payload: &gqlwsmessage.SubscribePayload{Query: `subscription{s}`}}))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// countingWriter counts the writes to the connection it hijacks, each of which is a flush of the socket
+type countingWriter struct {
+	http.ResponseWriter
+	writes *int64
+}
+
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	return &countingConn{Conn: conn, writes: w.writes}, brw, err
+}
+
+type countingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+func TestSocketWriteBatch(t *testing.T) {
+	burst := 64
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   `Query`,
+			Fields: graphql.Fields{"q": &graphql.Field{Type: graphql.String}},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Subscription`,
+			Fields: graphql.Fields{
+				"s": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						c := make(chan interface{}, burst)
+						for i := 0; i < burst; i++ {
+							c <- `hi`
+						}
+						close(c)
+						return c, nil
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	// flushes returns how many flushes the socket needs to send the burst and its completion
+	flushes := func(t *testing.T, cfg gqlwsserver.Config) int64 {
+		var writes int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg.Response, cfg.Request = &countingWriter{ResponseWriter: w, writes: &writes}, r
+			gqlwsserver.NewSocket(&cfg).Wait()
+		}))
+		defer server.Close()
+		conn, _, err := websocket.DefaultDialer.Dial(`ws`+strings.TrimPrefix(server.URL, `http`), http.Header{"Sec-WebSocket-Protocol": []string{`graphql-transport-ws`}})
+		assert.Nil(t, err)
+		defer conn.Close()
+		assert.Nil(t, conn.WriteJSON(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit}))
+		var msg gqlwsmessage.Message
+		assert.Nil(t, conn.ReadJSON(&msg))
+		assert.Equal(t, gqlwsmessage.ConnectionAck, msg.Type)
+		before := atomic.LoadInt64(&writes)
+		id := uuid.NewString()
+		assert.Nil(t, conn.WriteJSON(&gqlwsmessage.Message{Type: gqlwsmessage.Subscribe, ID: &id, Payload: &gqlwsmessage.SubscribePayload{Query: `subscription{s}`}}))
+		for i := 0; i < burst; i++ {
+			assert.Nil(t, conn.ReadJSON(&msg))
+			assert.Equal(t, gqlwsmessage.Next, msg.Type)
+		}
+		assert.Nil(t, conn.ReadJSON(&msg))
+		assert.Equal(t, gqlwsmessage.Complete, msg.Type)
+		return atomic.LoadInt64(&writes) - before
+	}
+	t.Run("flushes every message without batching", func(t *testing.T) {
+		assert.Equal(t, int64(burst+1), flushes(t, gqlwsserver.Config{Schema: &schema, WriteBatchSize: 1}))
+	})
+	t.Run("coalesces messages into batched flushes", func(t *testing.T) {
+		n := flushes(t, gqlwsserver.Config{Schema: &schema, WriteBatchSize: 32, WriteFlushLatency: time.Millisecond * 20})
+		assert.Positive(t, n)
+		assert.LessOrEqual(t, n, int64(burst/8))
+	})
+}
+
+func TestSocketCloseError(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"forbidden": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return nil, gqlwserror.ErrForbidden
+					},
+				},
+				"failed": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return nil, errors.New(`failed`)
+					},
+				},
+			},
+		}),
+	})
+	assert.Nil(t, err)
+	t.Run("resolver closes with its code", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema})
+		conn.Init(nil)
+		conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{forbidden}`})
+		conn.ExpectClose(gqlwserror.CloseForbidden)
+	})
+	t.Run("other resolver errors are results", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema})
+		conn.Init(nil)
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{failed}`})
+		conn.ExpectNext(id, gqlwstest.WithErrors(`failed`))
+	})
+	t.Run("hook closes with its code", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: &schema, OnConnectionInit: func(m *gqlwsmessage.Message) gqlwsmessage.Payload {
+			panic(gqlwserror.ErrUnauthorized)
+		}})
+		conn.Send(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit})
+		conn.ExpectClose(gqlwserror.CloseUnauthorized)
+	})
+}
+
+func TestSocketIdleTimeout(t *testing.T) {
+	timeout := time.Millisecond * 50
+	t.Run("closes an idle socket", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), IdleTimeout: timeout})
+		conn.Init(nil)
+		start := time.Now()
+		conn.ExpectClose(gqlwserror.CloseIdleTimeout)
+		assert.GreaterOrEqual(t, time.Since(start), timeout)
+	})
+	t.Run("waits for operations to end", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), IdleTimeout: timeout})
+		conn.Init(nil)
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+		deadline := time.Now().Add(timeout * 3)
+		for time.Now().Before(deadline) {
+			conn.ExpectNext(id)
+		}
+		conn.Complete(id)
+		start := time.Now()
+		// drain the results sent before the completion arrived
+		for {
+			if msg, err := conn.Receive(); err != nil || msg.Type != gqlwsmessage.Next {
+				break
+			}
+		}
+		conn.ExpectClose(gqlwserror.CloseIdleTimeout)
+		assert.GreaterOrEqual(t, time.Since(start), timeout/2)
+	})
+}
+
+func TestSocketBackpressure(t *testing.T) {
+	var dropped uint64
+	sock, conn := serveSocket(t, &gqlwsserver.Config{
+		Schema:              gqlwstest.Schema(t),
+		WriteBatchSize:      1,
+		OperationBufferSize: 1,
+		Backpressure:        gqlwsserver.BackpressureDropOldest,
+		OnDrop: func(id string, msg *gqlwsmessage.Message) {
+			assert.Equal(t, gqlwsmessage.Next, msg.Type)
+			atomic.AddUint64(&dropped, 1)
+		},
+	})
+	id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{s}`})
+	// the source ticks every millisecond while the client reads nothing
+	time.Sleep(time.Millisecond * 100)
+	assert.Positive(t, sock.Dropped())
+	conn.ExpectNext(id)
+	conn.Complete(id)
+	assert.Eventually(t, func() bool { return sock.Dropped() == atomic.LoadUint64(&dropped) }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,314 @@
+package gqlwsserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// SSETokenHeader carries the reservation token in single connection mode
+const SSETokenHeader = `X-GraphQL-Event-Stream-Token`
+
+const sseKeepAlive = time.Second * 12
+
+// SSEHandler serves the graphql-sse protocol in both distinct and single connection mode,
+// executing operations the same way a Socket does
+type SSEHandler struct {
+	*Config
+
+	lock    sync.Mutex
+	streams map[string]*sseStream
+}
+
+// sseStream is a reservation made in single connection mode
+type sseStream struct {
+	params ConnectionParams
+	events chan *sseEvent
+	sm     *subMan
+
+	lock sync.Mutex
+	open bool
+	// set once the reservation expired without its stream being opened
+	expired bool
+	expiry  *time.Timer
+	// closed once the event stream ends
+	done chan interface{}
+}
+
+type sseEvent struct {
+	event string
+	data  interface{}
+}
+
+func NewSSEHandler(cfg *Config) *SSEHandler {
+	var h SSEHandler
+	cfg.init()
+	h.Config = cfg
+	h.streams = make(map[string]*sseStream)
+	return &h
+}
+
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		h.reserve(w, r)
+		return
+	}
+	token := r.Header.Get(SSETokenHeader)
+	if token == `` {
+		token = r.URL.Query().Get(`token`)
+	}
+	if token == `` {
+		h.serveDistinct(w, r)
+		return
+	}
+	h.lock.Lock()
+	stream := h.streams[token]
+	h.lock.Unlock()
+	if stream == nil {
+		http.Error(w, `Stream not found`, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodDelete:
+		stream.sm.del(r.URL.Query().Get(`operationId`))
+		w.WriteHeader(http.StatusOK)
+	case acceptsEventStream(r):
+		h.serveStream(w, r, token, stream)
+	case r.Method == http.MethodPost || r.Method == http.MethodGet:
+		h.executeOnStream(w, r, stream)
+	default:
+		http.Error(w, `Method not allowed`, http.StatusMethodNotAllowed)
+	}
+}
+
+// reserve creates the stream of single connection mode. the token is returned as plain text
+func (h *SSEHandler) reserve(w http.ResponseWriter, r *http.Request) {
+	params, err := h.OnRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var stream sseStream
+	stream.params = params
+	stream.events = make(chan *sseEvent, h.OperationBufferSize)
+	stream.sm = newSubMan()
+	stream.done = make(chan interface{})
+	token := uuid.NewString()
+	h.lock.Lock()
+	h.streams[token] = &stream
+	h.lock.Unlock()
+	stream.lock.Lock()
+	stream.expiry = time.AfterFunc(h.SSEReservationTimeout, func() { h.expire(token, &stream) })
+	stream.lock.Unlock()
+	w.Header().Set(`Content-Type`, `text/plain; charset=utf-8`)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, token)
+}
+
+// serveStream delivers the events of every operation executed on the reservation until the client disconnects
+func (h *SSEHandler) serveStream(w http.ResponseWriter, r *http.Request, token string, stream *sseStream) {
+	stream.lock.Lock()
+	if stream.expired {
+		stream.lock.Unlock()
+		http.Error(w, `Stream not found`, http.StatusNotFound)
+		return
+	}
+	if stream.open {
+		stream.lock.Unlock()
+		http.Error(w, `Stream already open`, http.StatusConflict)
+		return
+	}
+	stream.open = true
+	stream.expiry.Stop()
+	stream.lock.Unlock()
+	defer func() {
+		h.lock.Lock()
+		delete(h.streams, token)
+		h.lock.Unlock()
+		close(stream.done)
+		stream.sm.clear()
+	}()
+	flusher, ok := startEventStream(w)
+	if !ok {
+		return
+	}
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev := <-stream.events:
+			if h.writeEvent(w, ev) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ":\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// expire discards a reservation whose stream was not opened within SSEReservationTimeout
+func (h *SSEHandler) expire(token string, stream *sseStream) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if stream.open {
+		return
+	}
+	stream.expired = true
+	h.lock.Lock()
+	delete(h.streams, token)
+	h.lock.Unlock()
+}
+
+// executeOnStream starts an operation whose events go to the reservation's stream.
+// operations are refused until the stream is open so that a reservation never holds any on its own
+func (h *SSEHandler) executeOnStream(w http.ResponseWriter, r *http.Request, stream *sseStream) {
+	stream.lock.Lock()
+	open := stream.open
+	stream.lock.Unlock()
+	if !open {
+		http.Error(w, `Stream is not open`, http.StatusConflict)
+		return
+	}
+	q, err := h.readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rejectMutationOverGET(w, r, q) {
+		return
+	}
+	id, _ := q.Extensions[`operationId`].(string)
+	if id == `` {
+		http.Error(w, `Operation ID is missing`, http.StatusBadRequest)
+		return
+	}
+	op, ok := stream.sm.add(id, q)
+	if !ok {
+		http.Error(w, `Operation with ID already exists`, http.StatusConflict)
+		return
+	}
+	go func() {
+		defer stream.sm.del(id)
+		send := func(ev *sseEvent) error {
+			select {
+			case stream.events <- ev:
+				return nil
+			case <-stream.done:
+				return errors.New(`stream closed`)
+			}
+		}
+		if h.executeHTTP(stream.params, q, op.stop, func(res *graphql.Result) error {
+			return send(&sseEvent{event: `next`, data: map[string]interface{}{"id": id, "payload": res}})
+		}) == nil {
+			send(&sseEvent{event: `complete`, data: map[string]interface{}{"id": id}})
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveDistinct executes one operation and streams its results in the response
+func (h *SSEHandler) serveDistinct(w http.ResponseWriter, r *http.Request) {
+	if !acceptsEventStream(r) {
+		http.Error(w, `Accept must include text/event-stream`, http.StatusNotAcceptable)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, `Method not allowed`, http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := h.readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rejectMutationOverGET(w, r, q) {
+		return
+	}
+	params, err := h.OnRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := startEventStream(w)
+	if !ok {
+		return
+	}
+	stop := make(chan interface{})
+	defer close(stop)
+	results := make(chan *graphql.Result)
+	go func() {
+		defer close(results)
+		h.executeHTTP(params, q, stop, func(res *graphql.Result) error {
+			select {
+			case results <- res:
+				return nil
+			case <-r.Context().Done():
+				return r.Context().Err()
+			}
+		})
+	}()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				h.writeEvent(w, &sseEvent{event: `complete`})
+				flusher.Flush()
+				return
+			}
+			if h.writeEvent(w, &sseEvent{event: `next`, data: res}) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ":\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *SSEHandler) writeEvent(w http.ResponseWriter, ev *sseEvent) error {
+	var data []byte
+	if ev.data != nil {
+		var err error
+		if data, err = h.Codec.Marshal(ev.data); err != nil {
+			data, _ = h.Codec.Marshal(&graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.event, data)
+	return err
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get(`Accept`), `text/event-stream`)
+}
+
+func startEventStream(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, `Streaming unsupported`, http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.Header().Set(`Connection`, `keep-alive`)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return flusher, true
+}
@@ -0,0 +1,245 @@
+package gqlwsserver_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSEHandler(t *testing.T) {
+	handler := gqlwsserver.NewSSEHandler(&gqlwsserver.Config{
+		Schema: gqlwstest.Schema(t),
+		OnRequest: func(r *http.Request) (gqlwsserver.ConnectionParams, error) {
+			return r.Header.Get(`Authorization`), nil
+		},
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	request := func(method, target, body string) *http.Request {
+		req, err := http.NewRequest(method, server.URL+target, strings.NewReader(body))
+		assert.Nil(t, err)
+		req.Header.Set(`Accept`, `text/event-stream`)
+		req.Header.Set(`Authorization`, `token`)
+		return req
+	}
+	// readEvent returns the event name and data of the next event
+	readEvent := func(r *bufio.Reader) (string, string) {
+		var event, data string
+		for {
+			line, err := r.ReadString('\n')
+			assert.Nil(t, err)
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == ``:
+				if event != `` {
+					return event, data
+				}
+			case strings.HasPrefix(line, `event: `):
+				event = strings.TrimPrefix(line, `event: `)
+			case strings.HasPrefix(line, `data: `):
+				data = strings.TrimPrefix(line, `data: `)
+			}
+		}
+	}
+	t.Run("distinct connection", func(t *testing.T) {
+		t.Run("can query", func(t *testing.T) {
+			res, err := http.DefaultClient.Do(request(http.MethodPost, ``, `{"query":"query{q p}"}`))
+			assert.Nil(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, `text/event-stream`, res.Header.Get(`Content-Type`))
+			body := bufio.NewReader(res.Body)
+			event, data := readEvent(body)
+			assert.Equal(t, `next`, event)
+			assert.JSONEq(t, `{"data":{"q":"hi","p":"token"}}`, data)
+			event, _ = readEvent(body)
+			assert.Equal(t, `complete`, event)
+		})
+		t.Run("can subscribe", func(t *testing.T) {
+			res, err := http.DefaultClient.Do(request(http.MethodGet, `?query=`+url.QueryEscape(`subscription{s}`), ``))
+			assert.Nil(t, err)
+			defer res.Body.Close()
+			body := bufio.NewReader(res.Body)
+			for i := 0; i < 10; i++ {
+				event, data := readEvent(body)
+				assert.Equal(t, `next`, event)
+				assert.JSONEq(t, `{"data":{"s":"hi"}}`, data)
+			}
+		})
+		t.Run("requires event stream", func(t *testing.T) {
+			req := request(http.MethodPost, ``, `{"query":"query{q}"}`)
+			req.Header.Del(`Accept`)
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusNotAcceptable, res.StatusCode)
+		})
+	})
+	t.Run("single connection", func(t *testing.T) {
+		res, err := http.DefaultClient.Do(request(http.MethodPut, ``, ``))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		token, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		res.Body.Close()
+		withToken := func(req *http.Request) *http.Request {
+			req.Header.Set(gqlwsserver.SSETokenHeader, string(token))
+			return req
+		}
+		stream, err := http.DefaultClient.Do(withToken(request(http.MethodGet, ``, ``)))
+		assert.Nil(t, err)
+		defer stream.Body.Close()
+		assert.Equal(t, http.StatusOK, stream.StatusCode)
+		body := bufio.NewReader(stream.Body)
+		execute := func(id, query string) {
+			req := withToken(request(http.MethodPost, ``, fmt.Sprintf(`{"query":%q,"extensions":{"operationId":%q}}`, query, id)))
+			req.Header.Set(`Accept`, `application/json`)
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusAccepted, res.StatusCode)
+		}
+		t.Run("rejects second stream", func(t *testing.T) {
+			res, err := http.DefaultClient.Do(withToken(request(http.MethodGet, ``, ``)))
+			assert.Nil(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusConflict, res.StatusCode)
+		})
+		t.Run("can query", func(t *testing.T) {
+			execute(`1`, `query{q p}`)
+			event, data := readEvent(body)
+			assert.Equal(t, `next`, event)
+			assert.JSONEq(t, `{"id":"1","payload":{"data":{"q":"hi","p":"token"}}}`, data)
+			event, data = readEvent(body)
+			assert.Equal(t, `complete`, event)
+			assert.JSONEq(t, `{"id":"1"}`, data)
+		})
+		t.Run("can subscribe and stop", func(t *testing.T) {
+			execute(`2`, `subscription{s}`)
+			for i := 0; i < 10; i++ {
+				event, data := readEvent(body)
+				assert.Equal(t, `next`, event)
+				assert.JSONEq(t, `{"id":"2","payload":{"data":{"s":"hi"}}}`, data)
+			}
+			res, err := http.DefaultClient.Do(withToken(request(http.MethodDelete, `?operationId=2`, ``)))
+			assert.Nil(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			for {
+				event, data := readEvent(body)
+				if event == `complete` {
+					assert.JSONEq(t, `{"id":"2"}`, data)
+					break
+				}
+			}
+		})
+	})
+}
+
+func TestSSEReservation(t *testing.T) {
+	handler := gqlwsserver.NewSSEHandler(&gqlwsserver.Config{
+		Schema:                gqlwstest.Schema(t),
+		SSEReservationTimeout: time.Millisecond * 50,
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	do := func(method, token, accept, body string) (*http.Response, string) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+		assert.Nil(t, err)
+		req.Header.Set(`Accept`, accept)
+		if token != `` {
+			req.Header.Set(gqlwsserver.SSETokenHeader, token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		return res, string(data)
+	}
+	reserve := func() string {
+		res, token := do(http.MethodPut, ``, ``, ``)
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		return token
+	}
+	t.Run("refuses operations before the stream is open", func(t *testing.T) {
+		res, _ := do(http.MethodPost, reserve(), `application/json`, `{"query":"subscription{s}","extensions":{"operationId":"1"}}`)
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+	})
+	t.Run("expires unopened reservations", func(t *testing.T) {
+		token := reserve()
+		time.Sleep(time.Millisecond * 200)
+		res, _ := do(http.MethodGet, token, `text/event-stream`, ``)
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func TestSSESelectedOperation(t *testing.T) {
+	var mutations int32
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   `Query`,
+			Fields: graphql.Fields{"q": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) { return `hi`, nil }}},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Mutation`,
+			Fields: graphql.Fields{"m": &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return atomic.AddInt32(&mutations, 1), nil
+			}}},
+		}),
+	})
+	assert.Nil(t, err)
+	server := httptest.NewServer(gqlwsserver.NewSSEHandler(&gqlwsserver.Config{Schema: &schema}))
+	defer server.Close()
+	query := `?query=` + url.QueryEscape(`query A{q} mutation B{m}`)
+	get := func(target, token, accept string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+target, nil)
+		assert.Nil(t, err)
+		req.Header.Set(`Accept`, accept)
+		if token != `` {
+			req.Header.Set(gqlwsserver.SSETokenHeader, token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		return res
+	}
+	t.Run("distinct connection rejects the mutation selected over GET", func(t *testing.T) {
+		res := get(query+`&operationName=B`, ``, `text/event-stream`)
+		res.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+		assert.Zero(t, atomic.LoadInt32(&mutations))
+	})
+	t.Run("single connection rejects the mutation selected over GET", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, server.URL, nil)
+		assert.Nil(t, err)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		token, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		res.Body.Close()
+		stream := get(``, string(token), `text/event-stream`)
+		defer stream.Body.Close()
+		extensions := `&extensions=` + url.QueryEscape(`{"operationId":"1"}`)
+		res = get(query+`&operationName=B`+extensions, string(token), `application/json`)
+		res.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+		res = get(query+`&operationName=A`+extensions, string(token), `application/json`)
+		res.Body.Close()
+		assert.Equal(t, http.StatusAccepted, res.StatusCode)
+		line, err := bufio.NewReader(stream.Body).ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "event: next\n", line)
+		assert.Zero(t, atomic.LoadInt32(&mutations))
+	})
+}
@@ -0,0 +1,78 @@
+package gqlwsserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// DocumentIDKey is the extension carrying the id of a trusted document.
+// the sha256Hash of the persistedQuery extension and a query consisting of the id alone are accepted too
+const DocumentIDKey = `documentId`
+
+// DocumentStore looks up trusted documents by id
+type DocumentStore interface {
+	Document(id string) (string, bool)
+}
+
+// Manifest maps the ids of trusted documents to their source. it is the DocumentStore read from a JSON file
+type Manifest map[string]string
+
+func (m Manifest) Document(id string) (string, bool) {
+	document, ok := m[id]
+	return document, ok
+}
+
+// Add registers document under its DocumentID, which is returned
+func (m Manifest) Add(document string) string {
+	id := DocumentID(document)
+	m[id] = document
+	return id
+}
+
+// LoadManifest reads a JSON object of ids to documents, as generated by cmd/gqlws-manifest
+func LoadManifest(path string) (Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DocumentID returns the id a document is registered under: the hex encoded SHA-256 of its source
+func DocumentID(document string) string {
+	sum := sha256.Sum256([]byte(document))
+	return hex.EncodeToString(sum[:])
+}
+
+var errUntrustedDocument = errors.New(`Document is not trusted`)
+
+// resolveDocument replaces the id q refers to by the trusted document. fails if the document is unknown
+func (c *Config) resolveDocument(q *gqlwsmessage.SubscribePayload) error {
+	if c.TrustedDocuments == nil {
+		return nil
+	}
+	id, _ := q.Extensions[DocumentIDKey].(string)
+	if id == `` {
+		if pq, ok := q.Extensions[`persistedQuery`].(map[string]interface{}); ok {
+			id, _ = pq[`sha256Hash`].(string)
+		}
+	}
+	if id == `` {
+		id = q.Query
+	}
+	document, ok := c.TrustedDocuments.Document(id)
+	if !ok {
+		return errUntrustedDocument
+	}
+	q.Query = document
+	return nil
+}
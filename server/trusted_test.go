@@ -0,0 +1,66 @@
+package gqlwsserver_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedDocuments(t *testing.T) {
+	manifest := gqlwsserver.Manifest{}
+	id := manifest.Add(`query Greeting{q}`)
+	cfg := func() *gqlwsserver.Config {
+		return &gqlwsserver.Config{Schema: gqlwstest.Schema(t), TrustedDocuments: manifest}
+	}
+	hi := gqlwstest.WithData(map[string]interface{}{`q`: `hi`})
+	t.Run("executes documents by id", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, cfg())
+		conn.Init(nil)
+		for _, q := range []gqlwsmessage.SubscribePayload{
+			{Extensions: map[string]interface{}{gqlwsserver.DocumentIDKey: id}},
+			{Extensions: map[string]interface{}{`persistedQuery`: map[string]interface{}{`version`: 1, `sha256Hash`: id}}},
+			{Query: id},
+		} {
+			op := conn.Subscribe(q)
+			conn.ExpectNext(op, hi)
+			conn.ExpectComplete(op)
+		}
+	})
+	t.Run("refuses unknown documents", func(t *testing.T) {
+		conn := gqlwstest.Serve(t, cfg())
+		conn.Init(nil)
+		conn.ExpectError(conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `{q}`}), gqlwstest.WithErrors(`not trusted`))
+		// the socket stays open
+		conn.ExpectNext(conn.Subscribe(gqlwsmessage.SubscribePayload{Query: id}), hi)
+	})
+	t.Run("refuses unknown documents over HTTP", func(t *testing.T) {
+		server := httptest.NewServer(gqlwsserver.NewHTTPHandler(cfg()))
+		defer server.Close()
+		res, err := http.Post(server.URL, `application/json`, strings.NewReader(`{"query":"{q}"}`))
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		res, err = http.Post(server.URL, `application/json`, strings.NewReader(`{"extensions":{"documentId":"`+id+`"}}`))
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		body, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.JSONEq(t, `{"data":{"q":"hi"}}`, string(body))
+	})
+	t.Run("loads manifest", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `manifest.json`)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(`{"`+id+`":"query Greeting{q}"}`), 0644))
+		loaded, err := gqlwsserver.LoadManifest(path)
+		assert.Nil(t, err)
+		assert.Equal(t, manifest, loaded)
+	})
+}
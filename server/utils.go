@@ -2,39 +2,86 @@ package gqlwsserver
 
 import (
 	"context"
+	"encoding/json"
+	"strconv"
 
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/graphql-go/graphql/language/parser"
 	"github.com/graphql-go/graphql/language/source"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
 )
 
-var connParamsKey = &struct{}{}
+type contextKey int
+
+const (
+	connParamsKey contextKey = iota
+	subscriptionStopKey
+	liveRunKey
+)
 
 func GetConnectionParams(ctx context.Context) ConnectionParams {
-	return ctx.Value(connParamsKey).(ConnectionParams)
+	params, _ := ctx.Value(connParamsKey).(ConnectionParams)
+	return params
 }
 
-var subscriptionStopKey = &struct{}{}
-
 func GetSubscriptionStopSig(ctx context.Context) chan interface{} {
 	return ctx.Value(subscriptionStopKey).(chan interface{})
 }
 
-func getOperationTypeOfReq(reqStr string) string {
-	source := source.NewSource(&source.Source{
-		Body: []byte(reqStr),
-		Name: "GraphQL request",
-	})
-
-	AST, err := parser.Parse(parser.ParseParams{Source: source})
+// getSelectedOperationType returns the type of the operation of q that graphql executes: the one named by
+// q.OperationName, or the only one of the document. returns an empty string if none is selected
+func getSelectedOperationType(q *gqlwsmessage.SubscribePayload) string {
+	AST, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(q.Query), Name: `GraphQL request`})})
 	if err != nil {
-		return ""
+		return ``
 	}
-
+	var selected *ast.OperationDefinition
 	for _, node := range AST.Definitions {
-		if operationDef, ok := node.(*ast.OperationDefinition); ok {
-			return operationDef.Operation
+		op, ok := node.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if q.OperationName == `` {
+			if selected != nil {
+				// graphql requires a name to pick one of several operations
+				return ``
+			}
+			selected = op
+		} else if op.Name != nil && op.Name.Value == q.OperationName {
+			selected = op
+		}
+	}
+	if selected == nil {
+		return ``
+	}
+	return selected.Operation
+}
+
+func isClosed(c chan interface{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// toUint64 converts a number decoded by any encoding
+func toUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case float64:
+		if n > 0 {
+			return uint64(n)
+		}
+	case int64:
+		if n > 0 {
+			return uint64(n)
 		}
+	case uint64:
+		return n
+	case json.Number:
+		u, _ := strconv.ParseUint(string(n), 10, 64)
+		return u
 	}
-	return ""
+	return 0
 }
@@ -0,0 +1,224 @@
+package gqlwstest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
+)
+
+// DefaultTimeout bounds every expectation of a Conn unless its Timeout is set
+var DefaultTimeout = time.Second * 5
+
+var errTimeout = errors.New(`timed out`)
+
+// dialGraceClosePeriod leaves the close frame of a dialed Conn the time to be written
+const dialGraceClosePeriod = time.Millisecond * 100
+
+// Conn is a scripted protocol client. the Expect methods fail the test on mismatch or timeout,
+// so they must be called from the goroutine running the test
+type Conn struct {
+	// Timeout bounds every expectation
+	Timeout time.Duration
+
+	t         testing.TB
+	transport gqlwstransport.Transport
+	msgs      chan *gqlwsmessage.Message
+	// closed once the connection ends, after which err is set
+	closed chan interface{}
+	err    error
+	stop   chan interface{}
+	once   sync.Once
+}
+
+// Dial connects to the websocket endpoint at url over graphql-transport-ws.
+// the connection is closed when the test ends
+func Dial(t testing.TB, url string) *Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{`Sec-WebSocket-Protocol`: []string{gqlwsmessage.Subprotocol}})
+	if err != nil {
+		t.Fatalf(`failed to dial %v: %v`, url, err)
+	}
+	return NewConn(t, gqlwstransport.NewWebSocket(conn, &gqlwstransport.WebSocketConfig{GraceClosePeriod: dialGraceClosePeriod}))
+}
+
+// NewConn scripts the protocol over transport. the transport is closed when the test ends
+func NewConn(t testing.TB, transport gqlwstransport.Transport) *Conn {
+	var c Conn
+	c.Timeout = DefaultTimeout
+	c.t = t
+	c.transport = transport
+	c.msgs = make(chan *gqlwsmessage.Message)
+	c.closed = make(chan interface{})
+	c.stop = make(chan interface{})
+	go c.read()
+	t.Cleanup(c.Close)
+	return &c
+}
+
+func (c *Conn) read() {
+	defer close(c.closed)
+	for {
+		msg, err := c.transport.ReadMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		if msg.Payload != nil {
+			var payload interface{}
+			if err := c.transport.Encoding().DecodePayload(msg.Payload, &payload); err != nil {
+				c.err = err
+				return
+			}
+			msg.Payload = payload
+		}
+		select {
+		case c.msgs <- msg:
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close ends the connection with a normal closure
+func (c *Conn) Close() {
+	c.once.Do(func() {
+		close(c.stop)
+		c.transport.Close(websocket.CloseNormalClosure, ``)
+	})
+}
+
+// Send writes msg as is
+func (c *Conn) Send(msg *gqlwsmessage.Message) {
+	c.t.Helper()
+	if err := c.transport.WriteMessage(msg); err != nil {
+		c.t.Fatalf(`failed to send %v: %v`, msg.Type, err)
+	}
+}
+
+// Init sends connection_init with payload and expects the ack, which is returned
+func (c *Conn) Init(payload interface{}) *gqlwsmessage.Message {
+	c.t.Helper()
+	c.Send(&gqlwsmessage.Message{Type: gqlwsmessage.ConnectionInit, Payload: payload})
+	return c.Expect(OfType(gqlwsmessage.ConnectionAck))
+}
+
+// Subscribe starts an operation under a new id, which is returned
+func (c *Conn) Subscribe(payload gqlwsmessage.SubscribePayload) string {
+	c.t.Helper()
+	id := uuid.NewString()
+	c.SubscribeWithID(id, payload)
+	return id
+}
+
+func (c *Conn) SubscribeWithID(id string, payload gqlwsmessage.SubscribePayload) {
+	c.t.Helper()
+	c.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Subscribe, ID: &id, Payload: &payload})
+}
+
+// Complete stops the operation of id
+func (c *Conn) Complete(id string) {
+	c.t.Helper()
+	c.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: &id})
+}
+
+func (c *Conn) Ping(payload interface{}) {
+	c.t.Helper()
+	c.Send(&gqlwsmessage.Message{Type: gqlwsmessage.Ping, Payload: payload})
+}
+
+// Receive returns the next message, or the error the connection ended with
+func (c *Conn) Receive() (*gqlwsmessage.Message, error) {
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-c.msgs:
+		return msg, nil
+	case <-c.closed:
+		return nil, c.err
+	case <-timer.C:
+		return nil, errTimeout
+	}
+}
+
+// Expect fails the test unless the next message passes all the matchers. the message is returned
+func (c *Conn) Expect(matchers ...Matcher) *gqlwsmessage.Message {
+	c.t.Helper()
+	msg, err := c.Receive()
+	if err != nil {
+		c.t.Fatalf(`expected a message: %v`, err)
+	}
+	for _, match := range matchers {
+		if err := match(msg); err != nil {
+			c.t.Fatalf(`unexpected message %v: %v`, dump(msg), err)
+		}
+	}
+	return msg
+}
+
+// ExpectNext expects a result of operation id and returns it
+func (c *Conn) ExpectNext(id string, matchers ...Matcher) *graphql.Result {
+	c.t.Helper()
+	msg := c.Expect(append([]Matcher{OfType(gqlwsmessage.Next), WithID(id)}, matchers...)...)
+	var res graphql.Result
+	if err := convert(msg.Payload, &res); err != nil {
+		c.t.Fatalf(`invalid result %v: %v`, dump(msg), err)
+	}
+	return &res
+}
+
+// ExpectError expects an error of operation id and returns it
+func (c *Conn) ExpectError(id string, matchers ...Matcher) gqlerrors.FormattedErrors {
+	c.t.Helper()
+	msg := c.Expect(append([]Matcher{OfType(gqlwsmessage.Error), WithID(id)}, matchers...)...)
+	var errs gqlerrors.FormattedErrors
+	if err := convert(msg.Payload, &errs); err != nil {
+		c.t.Fatalf(`invalid errors %v: %v`, dump(msg), err)
+	}
+	return errs
+}
+
+// ExpectComplete expects operation id to complete
+func (c *Conn) ExpectComplete(id string) {
+	c.t.Helper()
+	c.Expect(OfType(gqlwsmessage.Complete), WithID(id))
+}
+
+// ExpectClose expects the connection to end with code, without any message before
+func (c *Conn) ExpectClose(code int) {
+	c.t.Helper()
+	msg, err := c.Receive()
+	if msg != nil {
+		c.t.Fatalf(`expected close %v, got message %v`, code, dump(msg))
+	}
+	var ce *gqlwserror.CloseError
+	if !errors.As(err, &ce) {
+		c.t.Fatalf(`expected close %v: %v`, code, err)
+	}
+	if ce.Code() != code {
+		c.t.Fatalf(`expected close %v, got %v %v`, code, ce.Code(), ce.Reason())
+	}
+}
+
+func convert(src, dst interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func dump(msg *gqlwsmessage.Message) string {
+	data, _ := json.Marshal(msg)
+	return string(data)
+}
@@ -0,0 +1,133 @@
+package gqlwstest_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstest "github.com/onichandame/gql-ws/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConn(t *testing.T) {
+	srv := gqlwstest.NewServerFromSchema(t, gqlwstest.Schema(t))
+	t.Run(`query`, func(t *testing.T) {
+		conn := srv.Dial(t)
+		conn.Init(nil)
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `query{q}`})
+		res := conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"q": `hi`}))
+		assert.Empty(t, res.Errors)
+		conn.ExpectComplete(id)
+	})
+	t.Run(`subscription`, func(t *testing.T) {
+		conn := srv.Dial(t)
+		conn.Init(nil)
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `subscription{n}`})
+		for i := 0; i < 3; i++ {
+			conn.ExpectNext(id, gqlwstest.WithData(map[string]interface{}{"n": i}))
+		}
+		conn.ExpectComplete(id)
+	})
+	t.Run(`errors`, func(t *testing.T) {
+		conn := srv.Dial(t)
+		conn.Init(nil)
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `query{x}`})
+		conn.ExpectNext(id, gqlwstest.WithErrors(`Cannot query field "x"`))
+	})
+	t.Run(`ping`, func(t *testing.T) {
+		conn := srv.Dial(t)
+		conn.Ping(nil)
+		conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+	})
+	t.Run(`close`, func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t), ConnectionInitTimeout: time.Millisecond * 100})
+		conn.ExpectClose(gqlwserror.CloseInitTimeout)
+	})
+	t.Run(`in-memory`, func(t *testing.T) {
+		conn := gqlwstest.Serve(t, &gqlwsserver.Config{Schema: gqlwstest.Schema(t)})
+		conn.Init(nil)
+		id := conn.Subscribe(gqlwsmessage.SubscribePayload{Query: `query{q}`})
+		conn.ExpectNext(id)
+		conn.ExpectComplete(id)
+	})
+	t.Run(`timeout`, func(t *testing.T) {
+		conn := srv.Dial(t)
+		conn.Timeout = time.Millisecond * 100
+		_, err := conn.Receive()
+		assert.NotNil(t, err)
+	})
+}
+
+// fakeT records the failure of the expectations of a Conn in place of failing the test
+type fakeT struct {
+	testing.TB
+	failed chan string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Fatalf(format string, args ...interface{}) {
+	f.failed <- fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func TestConnFailure(t *testing.T) {
+	srv := gqlwstest.NewServerFromSchema(t, gqlwstest.Schema(t))
+	t.Run(`fails an expectation on timeout`, func(t *testing.T) {
+		ft := &fakeT{TB: t, failed: make(chan string, 1)}
+		conn := srv.Dial(ft)
+		conn.Timeout = time.Millisecond * 100
+		go conn.Expect(gqlwstest.OfType(gqlwsmessage.Pong))
+		select {
+		case msg := <-ft.failed:
+			assert.Contains(t, msg, `timed out`)
+		case <-time.After(time.Second):
+			t.Fatal(`the expectation hung`)
+		}
+	})
+	t.Run(`closes a dialed connection normally`, func(t *testing.T) {
+		codes := make(chan int, 1)
+		upgrader := websocket.Upgrader{Subprotocols: []string{gqlwsmessage.Subprotocol}}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _, err = conn.ReadMessage()
+			code := websocket.CloseAbnormalClosure
+			if ce, ok := err.(*websocket.CloseError); ok {
+				code = ce.Code
+			}
+			codes <- code
+		}))
+		defer server.Close()
+		gqlwstest.Dial(t, `ws`+strings.TrimPrefix(server.URL, `http`)).Close()
+		select {
+		case code := <-codes:
+			assert.Equal(t, websocket.CloseNormalClosure, code)
+		case <-time.After(time.Second):
+			t.Fatal(`the server did not see the connection close`)
+		}
+	})
+}
+
+func TestMatcher(t *testing.T) {
+	id := `1`
+	msg := &gqlwsmessage.Message{Type: gqlwsmessage.Next, ID: &id, Payload: map[string]interface{}{"data": map[string]interface{}{"q": `hi`}}}
+	assert.Nil(t, gqlwstest.OfType(gqlwsmessage.Next)(msg))
+	assert.NotNil(t, gqlwstest.OfType(gqlwsmessage.Complete)(msg))
+	assert.Nil(t, gqlwstest.WithID(`1`)(msg))
+	assert.NotNil(t, gqlwstest.WithID(`2`)(msg))
+	assert.Nil(t, gqlwstest.WithData(map[string]string{"q": `hi`})(msg))
+	assert.NotNil(t, gqlwstest.WithData(map[string]string{"q": `ho`})(msg))
+	assert.NotNil(t, gqlwstest.WithErrors(`oops`)(msg))
+}
@@ -0,0 +1,61 @@
+package gqlwstest
+
+import (
+	"sync"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// FrameTransport replays raw JSON frames to the end it is given to, then waits to be closed as if the peer had closed normally.
+// written messages are discarded. meant for fuzzing a Socket or a Client
+type FrameTransport struct {
+	frames [][]byte
+	done   chan struct{}
+	once   sync.Once
+	code   int
+}
+
+func NewFrameTransport(frames [][]byte) *FrameTransport {
+	return &FrameTransport{frames: frames, done: make(chan struct{})}
+}
+
+func (tr *FrameTransport) ReadMessage() (*gqlwsmessage.Message, error) {
+	if len(tr.frames) == 0 {
+		<-tr.done
+		return nil, gqlwserror.NewCloseError(gqlwserror.CloseNormal, ``)
+	}
+	data := tr.frames[0]
+	tr.frames = tr.frames[1:]
+	var msg gqlwsmessage.Message
+	if err := gqlwsmessage.JSON.Unmarshal(data, &msg); err != nil {
+		return nil, gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `Invalid message received`)
+	}
+	return &msg, nil
+}
+func (tr *FrameTransport) WriteMessage(msg *gqlwsmessage.Message) error {
+	select {
+	case <-tr.done:
+		return gqlwserror.NewCloseError(tr.code, ``)
+	default:
+		return nil
+	}
+}
+func (tr *FrameTransport) Close(code int, reason string) error {
+	tr.once.Do(func() {
+		tr.code = code
+		close(tr.done)
+	})
+	return nil
+}
+func (tr *FrameTransport) Encoding() gqlwsmessage.Encoding { return gqlwsmessage.JSON }
+
+// Code returns the close code the transport was closed with, or 0 while it is open
+func (tr *FrameTransport) Code() int {
+	select {
+	case <-tr.done:
+		return tr.code
+	default:
+		return 0
+	}
+}
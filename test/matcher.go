@@ -0,0 +1,94 @@
+package gqlwstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// Matcher checks a received message. the returned error describes the mismatch
+type Matcher func(msg *gqlwsmessage.Message) error
+
+// OfType matches messages of type typ
+func OfType(typ gqlwsmessage.Type) Matcher {
+	return func(msg *gqlwsmessage.Message) error {
+		if msg.Type != typ {
+			return fmt.Errorf(`expected type %v, got %v`, typ, msg.Type)
+		}
+		return nil
+	}
+}
+
+// WithID matches messages of operation id
+func WithID(id string) Matcher {
+	return func(msg *gqlwsmessage.Message) error {
+		if msg.ID == nil {
+			return fmt.Errorf(`expected id %v, got none`, id)
+		}
+		if *msg.ID != id {
+			return fmt.Errorf(`expected id %v, got %v`, id, *msg.ID)
+		}
+		return nil
+	}
+}
+
+// WithData matches next messages whose result data equals data once both are encoded in JSON
+func WithData(data interface{}) Matcher {
+	return func(msg *gqlwsmessage.Message) error {
+		payload, _ := msg.Payload.(map[string]interface{})
+		expected, err := normalize(data)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(expected, payload[`data`]) {
+			return fmt.Errorf(`expected data %v, got %v`, expected, payload[`data`])
+		}
+		return nil
+	}
+}
+
+// WithErrors matches next and error messages carrying an error for each of the messages, in order.
+// an error matches when its message contains the expected one
+func WithErrors(messages ...string) Matcher {
+	return func(msg *gqlwsmessage.Message) error {
+		var errs []interface{}
+		switch p := msg.Payload.(type) {
+		case []interface{}:
+			errs = p
+		case map[string]interface{}:
+			errs, _ = p[`errors`].([]interface{})
+		}
+		if len(errs) != len(messages) {
+			return fmt.Errorf(`expected %v errors, got %v`, len(messages), len(errs))
+		}
+		for i, expected := range messages {
+			e, _ := errs[i].(map[string]interface{})
+			actual, _ := e[`message`].(string)
+			if !strings.Contains(actual, expected) {
+				return fmt.Errorf(`expected error %q, got %q`, expected, actual)
+			}
+		}
+		return nil
+	}
+}
+
+// WithPayload matches messages whose payload passes check
+func WithPayload(check func(payload gqlwsmessage.Payload) error) Matcher {
+	return func(msg *gqlwsmessage.Message) error {
+		return check(msg.Payload)
+	}
+}
+
+// normalize turns v into the generic form of its JSON encoding
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var res interface{}
+	err = json.Unmarshal(data, &res)
+	return res, err
+}
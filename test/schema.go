@@ -0,0 +1,94 @@
+package gqlwstest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+)
+
+// Schema returns the schema the tests of this module run against:
+//   - query q resolves to "hi"
+//   - query p echoes the connection params
+//   - subscription s sends "hi" every millisecond until it is stopped
+//   - subscription n counts from 0 to 2 then completes
+func Schema(t testing.TB) *graphql.Schema {
+	t.Helper()
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Query`,
+			Fields: graphql.Fields{
+				"q": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return `hi`, nil
+					},
+				},
+				"p": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return fmt.Sprint(gqlwsserver.GetConnectionParams(p.Context)), nil
+					},
+				},
+			},
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name: `Subscription`,
+			Fields: graphql.Fields{
+				"s": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						c := make(chan interface{})
+						go func() {
+							defer close(c)
+							ticker := time.NewTicker(time.Millisecond)
+							defer ticker.Stop()
+							for {
+								select {
+								case <-p.Context.Done():
+									return
+								case <-ticker.C:
+									select {
+									case c <- `hi`:
+									case <-p.Context.Done():
+										return
+									}
+								}
+							}
+						}()
+						return c, nil
+					},
+				},
+				"n": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+					Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+						c := make(chan interface{})
+						go func() {
+							defer close(c)
+							for i := 0; i < 3; i++ {
+								select {
+								case c <- i:
+								case <-p.Context.Done():
+									return
+								}
+							}
+						}()
+						return c, nil
+					},
+				},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatalf(`invalid schema: %v`, err)
+	}
+	return &schema
+}
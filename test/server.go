@@ -0,0 +1,45 @@
+package gqlwstest
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	gqlwsserver "github.com/onichandame/gql-ws/server"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
+)
+
+// Server serves cfg over HTTP and websocket until the test ends
+type Server struct {
+	*httptest.Server
+	// WebSocketURL is the ws:// form of URL
+	WebSocketURL string
+}
+
+func NewServer(t testing.TB, cfg *gqlwsserver.Config) *Server {
+	var s Server
+	s.Server = httptest.NewServer(gqlwsserver.NewHTTPHandler(cfg))
+	s.WebSocketURL = `ws` + strings.TrimPrefix(s.URL, `http`)
+	t.Cleanup(s.Close)
+	return &s
+}
+
+// NewServerFromSchema serves schema with the default config
+func NewServerFromSchema(t testing.TB, schema *graphql.Schema) *Server {
+	return NewServer(t, &gqlwsserver.Config{Schema: schema})
+}
+
+// Dial connects a Conn to the server
+func (s *Server) Dial(t testing.TB) *Conn {
+	t.Helper()
+	return Dial(t, s.WebSocketURL)
+}
+
+// Serve runs a Socket of cfg in-memory and returns a Conn to it. cfg.Transport is replaced
+func Serve(t testing.TB, cfg *gqlwsserver.Config) *Conn {
+	clientEnd, serverEnd := gqlwstransport.Pipe()
+	cfg.Transport = serverEnd
+	go func() { gqlwsserver.NewSocket(cfg).Wait() }()
+	return NewConn(t, clientEnd)
+}
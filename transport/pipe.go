@@ -0,0 +1,66 @@
+package gqlwstransport
+
+import (
+	"sync"
+
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+// pipe is the state shared by both ends of an in-memory connection
+type pipe struct {
+	once   sync.Once
+	done   chan struct{}
+	closed *gqlwserror.CloseError
+}
+
+type pipeEnd struct {
+	*pipe
+	in  <-chan []byte
+	out chan<- []byte
+}
+
+// Pipe returns the two ends of an in-memory connection, so a client and a socket can talk in-process.
+// messages are encoded in JSON on the way as they would be on the wire
+func Pipe() (Transport, Transport) {
+	var p pipe
+	p.done = make(chan struct{})
+	a, b := make(chan []byte), make(chan []byte)
+	return &pipeEnd{pipe: &p, in: a, out: b}, &pipeEnd{pipe: &p, in: b, out: a}
+}
+
+func (e *pipeEnd) ReadMessage() (*gqlwsmessage.Message, error) {
+	select {
+	case data := <-e.in:
+		var msg gqlwsmessage.Message
+		if err := gqlwsmessage.JSON.Unmarshal(data, &msg); err != nil {
+			return nil, gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `Invalid message received`)
+		}
+		return &msg, nil
+	case <-e.done:
+		return nil, e.closed
+	}
+}
+
+func (e *pipeEnd) WriteMessage(msg *gqlwsmessage.Message) error {
+	data, err := gqlwsmessage.JSON.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case e.out <- data:
+		return nil
+	case <-e.done:
+		return e.closed
+	}
+}
+
+func (e *pipeEnd) Close(code int, reason string) error {
+	e.once.Do(func() {
+		e.closed = gqlwserror.NewCloseError(code, reason)
+		close(e.done)
+	})
+	return nil
+}
+
+func (e *pipeEnd) Encoding() gqlwsmessage.Encoding { return gqlwsmessage.JSON }
@@ -0,0 +1,43 @@
+package gqlwstransport_test
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+	gqlwstransport "github.com/onichandame/gql-ws/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipe(t *testing.T) {
+	t.Run("carries messages both ways", func(t *testing.T) {
+		a, b := gqlwstransport.Pipe()
+		id := `id`
+		go func() {
+			assert.Nil(t, a.WriteMessage(&gqlwsmessage.Message{Type: gqlwsmessage.Next, ID: &id, Payload: &graphql.Result{Data: `hi`}}))
+		}()
+		msg, err := b.ReadMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, gqlwsmessage.Next, msg.Type)
+		assert.Equal(t, id, *msg.ID)
+		var res graphql.Result
+		assert.Nil(t, b.Encoding().DecodePayload(msg.Payload, &res))
+		assert.Equal(t, `hi`, res.Data)
+		go func() {
+			assert.Nil(t, b.WriteMessage(&gqlwsmessage.Message{Type: gqlwsmessage.Complete, ID: &id}))
+		}()
+		msg, err = a.ReadMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, gqlwsmessage.Complete, msg.Type)
+	})
+	t.Run("delivers close code", func(t *testing.T) {
+		a, b := gqlwstransport.Pipe()
+		assert.Nil(t, a.Close(4408, `timeout`))
+		_, err := b.ReadMessage()
+		assert.IsType(t, new(gqlwserror.CloseError), err)
+		assert.Equal(t, 4408, err.(*gqlwserror.CloseError).Code())
+		assert.Equal(t, `timeout`, err.(*gqlwserror.CloseError).Reason())
+		assert.NotNil(t, a.WriteMessage(&gqlwsmessage.Message{Type: gqlwsmessage.Ping}))
+	})
+}
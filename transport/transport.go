@@ -0,0 +1,15 @@
+package gqlwstransport
+
+import gqlwsmessage "github.com/onichandame/gql-ws/message"
+
+// Transport carries protocol messages between a client and a server
+type Transport interface {
+	// ReadMessage blocks until the next message arrives.
+	// once the peer closes the connection it returns a *gqlwserror.CloseError carrying the close code
+	ReadMessage() (*gqlwsmessage.Message, error)
+	WriteMessage(*gqlwsmessage.Message) error
+	// Close ends the connection with a close code and reason
+	Close(code int, reason string) error
+	// Encoding decodes the payloads of the messages read
+	Encoding() gqlwsmessage.Encoding
+}
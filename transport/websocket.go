@@ -0,0 +1,77 @@
+package gqlwstransport
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	gqlwserror "github.com/onichandame/gql-ws/error"
+	gqlwsmessage "github.com/onichandame/gql-ws/message"
+)
+
+type WebSocketConfig struct {
+	// Encoding is the one negotiated through the subprotocol
+	Encoding gqlwsmessage.Encoding
+	// EnableCompression compresses the messages of at least CompressionThreshold bytes once permessage-deflate is negotiated
+	EnableCompression    bool
+	CompressionThreshold int
+	// GraceClosePeriod is how long the connection stays open after the close frame is sent
+	GraceClosePeriod time.Duration
+}
+
+// WebSocket is the Transport over a websocket connection
+type WebSocket struct {
+	cfg  *WebSocketConfig
+	conn *websocket.Conn
+}
+
+func NewWebSocket(conn *websocket.Conn, cfg *WebSocketConfig) *WebSocket {
+	var ws WebSocket
+	if cfg.Encoding == nil {
+		cfg.Encoding = gqlwsmessage.JSON
+	}
+	ws.cfg = cfg
+	ws.conn = conn
+	return &ws
+}
+
+func (ws *WebSocket) ReadMessage() (*gqlwsmessage.Message, error) {
+	_, data, err := ws.conn.ReadMessage()
+	if err != nil {
+		var ce *websocket.CloseError
+		if errors.As(err, &ce) {
+			return nil, gqlwserror.NewCloseError(ce.Code, ce.Text)
+		}
+		return nil, err
+	}
+	var msg gqlwsmessage.Message
+	if err := ws.cfg.Encoding.Unmarshal(data, &msg); err != nil {
+		return nil, gqlwserror.NewCloseError(gqlwserror.CloseBadRequest, `Invalid message received`)
+	}
+	return &msg, nil
+}
+
+// WriteMessage sends msg, compressing it if it is large enough
+func (ws *WebSocket) WriteMessage(msg *gqlwsmessage.Message) error {
+	data, err := ws.cfg.Encoding.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	frame := websocket.TextMessage
+	if ws.cfg.Encoding.Binary() {
+		frame = websocket.BinaryMessage
+	}
+	ws.conn.EnableWriteCompression(ws.cfg.EnableCompression && len(data) >= ws.cfg.CompressionThreshold)
+	return ws.conn.WriteMessage(frame, data)
+}
+
+func (ws *WebSocket) Close(code int, reason string) error {
+	defer ws.conn.Close()
+	if err := ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(ws.cfg.GraceClosePeriod)); err != nil {
+		return err
+	}
+	time.Sleep(ws.cfg.GraceClosePeriod)
+	return nil
+}
+
+func (ws *WebSocket) Encoding() gqlwsmessage.Encoding { return ws.cfg.Encoding }